@@ -7,4 +7,5 @@ require github.com/golang/protobuf v1.5.2
 require (
 	github.com/go-sql-driver/mysql v1.7.1
 	google.golang.org/protobuf v1.26.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,272 @@
+package arc
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/qingants/pandora/minicache/lru"
+)
+
+// Value is use Len to count how many bytes it takes
+type Value = lru.Value
+
+// Cache is an Adaptive Replacement Cache weighted by bytes.
+// t1 holds entries seen once recently and t2 entries seen at least twice,
+// b1 and b2 remember the keys recently evicted from them. A hit in a ghost
+// list moves the target size p of t1 towards the list that would have hit.
+type Cache struct {
+	maxBytes  int64
+	p         int64 // target bytes of t1
+	t1, t2    *segment
+	b1, b2    *segment
+	cache     map[string]*list.Element
+	OnEvicted func(key string, value Value)
+}
+
+type segment struct {
+	ll     *list.List
+	nbytes int64
+}
+
+func newSegment() *segment {
+	return &segment{ll: list.New()}
+}
+
+type entry struct {
+	key    string
+	value  Value // nil for ghosts
+	size   int64
+	expire time.Time // zero means never expire
+	seg    *segment
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+func New(maxBytes int64, onEvited func(string, Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        newSegment(),
+		t2:        newSegment(),
+		b1:        newSegment(),
+		b2:        newSegment(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvited,
+	}
+}
+
+func (c *Cache) resident(ele *list.Element) bool {
+	seg := ele.Value.(*entry).seg
+	return seg == c.t1 || seg == c.t2
+}
+
+// Get looks up a key's value, an expired entry is removed and reported as a miss
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok || !c.resident(ele) {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	if kv.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.move(ele, c.t2)
+	return kv.value, true
+}
+
+// Disuse evicts the resident entry replace would pick first
+func (c *Cache) Disuse() {
+	if c.t1.ll.Len() > 0 && (c.t1.nbytes > c.p || c.t2.ll.Len() == 0) {
+		c.evict(c.t1.ll.Back(), c.b1)
+	} else if c.t2.ll.Len() > 0 {
+		c.evict(c.t2.ll.Back(), c.b2)
+	}
+	c.trimGhosts()
+}
+
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+// RemoveExpired drops every expired entry and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, seg := range []*segment{c.t1, c.t2} {
+		for ele := seg.ll.Back(); ele != nil; {
+			prev := ele.Prev()
+			if ele.Value.(*entry).expired(now) {
+				c.removeElement(ele)
+				n++
+			}
+			ele = prev
+		}
+	}
+	return n
+}
+
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value which is treated as missing once expire has passed,
+// a zero expire means the value never expires
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	size := int64(len(key)) + int64(value.Len())
+	ele, ok := c.cache[key]
+	if !ok {
+		c.cache[key] = c.push(c.t1, &entry{key: key, value: value, size: size, expire: expire})
+		c.replace(false)
+		c.trimGhosts()
+		return
+	}
+
+	kv := ele.Value.(*entry)
+	inB2 := kv.seg == c.b2
+	switch kv.seg {
+	case c.b1:
+		c.p = min(c.capacity(), c.p+max(ratio(c.b2.nbytes, c.b1.nbytes), 1)*size)
+	case c.b2:
+		c.p = max(0, c.p-max(ratio(c.b1.nbytes, c.b2.nbytes), 1)*size)
+	}
+	c.unlink(ele)
+	kv.value = value
+	kv.size = size
+	kv.expire = expire
+	c.cache[key] = c.push(c.t2, kv)
+	c.replace(inB2)
+	c.trimGhosts()
+}
+
+// replace evicts resident entries into the ghost lists until under budget
+func (c *Cache) replace(inB2 bool) {
+	for c.maxBytes != 0 && c.t1.nbytes+c.t2.nbytes > c.maxBytes {
+		var ele *list.Element
+		if c.t1.ll.Len() > 0 && (c.t1.nbytes > c.p || (inB2 && c.t1.nbytes == c.p) || c.t2.ll.Len() == 0) {
+			ele = c.t1.ll.Back()
+			c.evict(ele, c.b1)
+		} else {
+			ele = c.t2.ll.Back()
+			c.evict(ele, c.b2)
+		}
+	}
+}
+
+// trimGhosts keeps t1+b1 and the whole directory within bounds. Without
+// maxBytes the cache is bounded by its owner through Disuse, ghosts then
+// remember no more bytes than are resident
+func (c *Cache) trimGhosts() {
+	if c.maxBytes == 0 {
+		for c.b1.nbytes+c.b2.nbytes > c.Bytes() {
+			if c.b1.nbytes >= c.b2.nbytes {
+				c.forget(c.b1.ll.Back())
+			} else {
+				c.forget(c.b2.ll.Back())
+			}
+		}
+		return
+	}
+	for c.b1.ll.Len() > 0 && c.t1.nbytes+c.b1.nbytes > c.maxBytes {
+		c.forget(c.b1.ll.Back())
+	}
+	for c.b2.ll.Len() > 0 && c.t1.nbytes+c.t2.nbytes+c.b1.nbytes+c.b2.nbytes > 2*c.maxBytes {
+		c.forget(c.b2.ll.Back())
+	}
+}
+
+func (c *Cache) evict(ele *list.Element, ghost *segment) {
+	kv := ele.Value.(*entry)
+	value := kv.value
+	c.unlink(ele)
+	kv.value = nil
+	c.cache[kv.key] = c.push(ghost, kv)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, value)
+	}
+}
+
+func (c *Cache) forget(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	c.unlink(ele)
+	delete(c.cache, kv.key)
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	resident := c.resident(ele)
+	kv := ele.Value.(*entry)
+	c.forget(ele)
+	if resident && c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+func (c *Cache) move(ele *list.Element, seg *segment) {
+	kv := ele.Value.(*entry)
+	c.unlink(ele)
+	c.cache[kv.key] = c.push(seg, kv)
+}
+
+func (c *Cache) push(seg *segment, kv *entry) *list.Element {
+	kv.seg = seg
+	seg.nbytes += kv.size
+	return seg.ll.PushFront(kv)
+}
+
+func (c *Cache) unlink(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	kv.seg.ll.Remove(ele)
+	kv.seg.nbytes -= kv.size
+}
+
+// Walk calls fn for every resident entry, t1 then t2 and least
+// recently used first within each
+func (c *Cache) Walk(fn func(key string, value Value, expire time.Time)) {
+	for _, seg := range []*segment{c.t1, c.t2} {
+		for ele := seg.ll.Back(); ele != nil; ele = ele.Prev() {
+			kv := ele.Value.(*entry)
+			fn(kv.key, kv.value, kv.expire)
+		}
+	}
+}
+
+func (c *Cache) Len() int {
+	return c.t1.ll.Len() + c.t2.ll.Len()
+}
+
+// Bytes returns the bytes taken by resident keys and values
+func (c *Cache) Bytes() int64 {
+	return c.t1.nbytes + c.t2.nbytes
+}
+
+// capacity is maxBytes, or the resident bytes of a cache bounded through Disuse
+func (c *Cache) capacity() int64 {
+	if c.maxBytes == 0 {
+		return c.Bytes()
+	}
+	return c.maxBytes
+}
+
+func ratio(a, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+	return a / b
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
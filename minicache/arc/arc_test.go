@@ -0,0 +1,106 @@
+package arc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Str string
+
+func (d Str) Len() int {
+	return len(d)
+}
+
+func TestCache_Get(t *testing.T) {
+	arc := New(0, nil)
+	arc.Add("first", Str("abcd"))
+	if v, ok := arc.Get("first"); !ok || string(v.(Str)) != "abcd" {
+		t.Fatalf("cache hit first=abcd failed")
+	}
+	if _, ok := arc.Get("second"); ok {
+		t.Fatalf("cache miss second failed")
+	}
+}
+
+func TestCache_ScanResistant(t *testing.T) {
+	keys := make([]string, 0)
+	arc := New(int64(len("k1v1k2v2k3v3")), func(key string, value Value) {
+		keys = append(keys, key)
+	})
+	arc.Add("k1", Str("v1"))
+	arc.Add("k2", Str("v2"))
+	arc.Get("k1")
+	arc.Get("k2")
+
+	// a scan of keys seen once must not flush the frequent ones
+	for _, k := range []string{"s1", "s2", "s3", "s4"} {
+		arc.Add(k, Str("vv"))
+	}
+	if expect := []string{"s1", "s2", "s3"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("evicted keys %s, expect %s", keys, expect)
+	}
+	for _, k := range []string{"k1", "k2", "s4"} {
+		if _, ok := arc.Get(k); !ok {
+			t.Fatalf("expect %s to stay", k)
+		}
+	}
+}
+
+func TestCache_Ghost(t *testing.T) {
+	arc := New(int64(len("k1v1k2v2")), nil)
+	arc.Add("k1", Str("v1"))
+	arc.Get("k1")
+	arc.Add("k2", Str("v2"))
+	arc.Add("k3", Str("v3"))
+
+	if _, ok := arc.Get("k2"); ok || arc.Len() != 2 || arc.b1.ll.Len() != 1 {
+		t.Fatalf("k2 should be evicted into the ghost list")
+	}
+	arc.Add("k2", Str("v2"))
+	if arc.p == 0 {
+		t.Fatalf("a ghost hit in b1 should grow the target of t1")
+	}
+	if _, ok := arc.Get("k2"); !ok || arc.Bytes() > arc.maxBytes {
+		t.Fatalf("k2 should be back, bytes %d", arc.Bytes())
+	}
+}
+
+func TestCache_GhostsWithoutMaxBytes(t *testing.T) {
+	// a group sharing a Budget has no maxBytes, the Budget keeps it
+	// under its share by calling Disuse
+	const budget = 1000
+	arc := New(0, nil)
+	for i := 0; i < 100000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		arc.Add(key, Str("0123456789"))
+		if i%3 == 0 {
+			arc.Get(key)
+		}
+		for arc.Bytes() > budget {
+			arc.Disuse()
+		}
+	}
+	if ghosts := arc.b1.nbytes + arc.b2.nbytes; ghosts > arc.Bytes() {
+		t.Fatalf("ghosts remember %d bytes for %d resident", ghosts, arc.Bytes())
+	}
+	if n := len(arc.cache); n > 2*arc.Len() {
+		t.Fatalf("directory holds %d keys for %d resident", n, arc.Len())
+	}
+}
+
+func TestCache_Expire(t *testing.T) {
+	arc := New(0, nil)
+	past := time.Now().Add(-time.Second)
+	arc.AddWithExpire("k1", Str("v1"), past)
+	arc.Add("k2", Str("v2"))
+	arc.AddWithExpire("k3", Str("v3"), past)
+
+	if _, ok := arc.Get("k1"); ok || arc.Len() != 2 {
+		t.Fatalf("expired k1 should be a miss and removed")
+	}
+	if n := arc.RemoveExpired(); n != 1 || arc.Len() != 1 || arc.Bytes() != 4 {
+		t.Fatalf("RemoveExpired() = %d, len %d, bytes %d", n, arc.Len(), arc.Bytes())
+	}
+}
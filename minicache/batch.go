@@ -0,0 +1,427 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+// BatchGetter is implemented by getters able to load many keys in one call,
+// keys missing from the result are reported with ErrNotFound
+type BatchGetter interface {
+	GetMany(keys []string) (map[string][]byte, error)
+}
+
+type BatchGetterFunc func(keys []string) (map[string][]byte, error)
+
+func (f BatchGetterFunc) Get(key string) ([]byte, error) {
+	values, err := f([]string{key})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (f BatchGetterFunc) GetMany(keys []string) (map[string][]byte, error) {
+	return f(keys)
+}
+
+// ExpireBatchGetter is a BatchGetter whose values go stale, keys missing
+// from expires never expire. It is preferred over GetMany
+type ExpireBatchGetter interface {
+	GetManyWithExpire(keys []string) (values map[string][]byte, expires map[string]time.Time, err error)
+}
+
+type ExpireBatchGetterFunc func(keys []string) (map[string][]byte, map[string]time.Time, error)
+
+func (f ExpireBatchGetterFunc) Get(key string) ([]byte, error) {
+	value, _, err := f.GetWithExpire(key)
+	return value, err
+}
+
+func (f ExpireBatchGetterFunc) GetWithExpire(key string) ([]byte, time.Time, error) {
+	values, expires, err := f([]string{key})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, time.Time{}, ErrNotFound
+	}
+	return value, expires[key], nil
+}
+
+func (f ExpireBatchGetterFunc) GetManyWithExpire(keys []string) (map[string][]byte, map[string]time.Time, error) {
+	return f(keys)
+}
+
+// BatchError maps the keys GetMany failed to get to their errors
+type BatchError map[string]error
+
+func (e BatchError) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, len(keys))
+	for i, key := range keys {
+		msgs[i] = fmt.Sprintf("%s: %v", key, e[key])
+	}
+	return fmt.Sprintf("%d keys failed: %s", len(keys), strings.Join(msgs, "; "))
+}
+
+// GetMany serves cached keys locally, sends one batched request to every
+// peer owning some of the rest and loads the keys this process owns,
+// in a single call if the getter is a BatchGetter.
+// The values found are returned even if some keys failed with a BatchError.
+func (g *Group) GetMany(keys []string) (map[string]ByteView, error) {
+	return g.GetManyContext(context.Background(), keys)
+}
+
+func (g *Group) GetManyContext(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, err := g.getMany(ctx, keys)
+	errs, _ := err.(BatchError)
+	for key, v := range values {
+		if v, err = g.decompressed(key, v); err != nil {
+			if errs == nil {
+				errs = BatchError{}
+			}
+			errs[key] = err
+			delete(values, key)
+			continue
+		}
+		values[key] = v
+	}
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+// getMany is GetManyContext keeping compressed values compressed
+func (g *Group) getMany(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := BatchError{}
+	seen := make(map[string]bool, len(keys))
+	var missing []string
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			errs[key] = fmt.Errorf("key is required")
+			continue
+		}
+		g.stats.gets.Add(1)
+		if v, ok := g.lookupCache(key); ok {
+			g.stats.cacheHits.Add(1)
+			if v.notFound {
+				g.stats.negativeHits.Add(1)
+				errs[key] = &notFoundError{key: key, expire: v.e}
+			} else {
+				g.revalidate(key, v)
+				values[key] = v
+			}
+			continue
+		}
+		missing = append(missing, key)
+	}
+	g.stats.loads.Add(int64(len(missing)))
+
+	var local, fallback []string
+	if g.peers == nil {
+		local = missing
+	} else {
+		// keys go to their first owner, those of a failed peer to the next
+		// owner of each key until none is left
+		replicas := make(map[string][]PeerGetter)
+		var remote []string
+		for _, key := range missing {
+			if peers, isOwner := g.owners(key); !isOwner && len(peers) > 0 {
+				replicas[key] = peers
+				remote = append(remote, key)
+			} else {
+				local = append(local, key)
+			}
+		}
+
+		for attempt := 0; len(remote) > 0; attempt++ {
+			owners := make(map[PeerGetter][]string)
+			for _, key := range remote {
+				peer := replicas[key][attempt]
+				owners[peer] = append(owners[peer], key)
+			}
+			var (
+				wg    sync.WaitGroup
+				lock  sync.Mutex
+				retry []string
+			)
+			for peer, peerKeys := range owners {
+				wg.Add(1)
+				go func(peer PeerGetter, peerKeys []string) {
+					defer wg.Done()
+					got, failed, err := g.getManyFromPeer(ctx, peer, peerKeys)
+					lock.Lock()
+					defer lock.Unlock()
+					if err != nil {
+						g.stats.peerErrors.Add(1)
+						g.stats.peer(peerAddr(peer)).errors.Add(1)
+						log.Printf("[MiniCache] Failed to get batch from peer %s %v", peerAddr(peer), err)
+						for _, key := range peerKeys {
+							if attempt+1 < len(replicas[key]) {
+								retry = append(retry, key)
+							} else {
+								fallback = append(fallback, key)
+							}
+						}
+						return
+					}
+					for key, v := range got {
+						values[key] = v
+					}
+					for key, err := range failed {
+						errs[key] = err
+					}
+				}(peer, peerKeys)
+			}
+			wg.Wait()
+			if err := ctx.Err(); err != nil {
+				for _, key := range append(retry, fallback...) {
+					errs[key] = err
+				}
+				retry, fallback = nil, nil
+			}
+			remote = retry
+		}
+	}
+
+	merge := func(got map[string]ByteView, failed BatchError) {
+		for key, v := range got {
+			values[key] = v
+		}
+		for key, err := range failed {
+			errs[key] = err
+		}
+	}
+	if len(local) > 0 {
+		merge(g.getManyLocally(ctx, local, true))
+	}
+	// keys of failed peers are loaded without keeping a copy, this process does not own them
+	if len(fallback) > 0 {
+		merge(g.getManyLocally(ctx, fallback, false))
+	}
+
+	if len(errs) > 0 {
+		return values, errs
+	}
+	return values, nil
+}
+
+func (g *Group) getManyFromPeer(ctx context.Context, peer PeerGetter, keys []string) (map[string]ByteView, BatchError, error) {
+	req := &pb.BatchRequest{
+		Group:          g.name,
+		Keys:           keys,
+		AcceptEncoding: g.acceptEncoding(),
+	}
+	res := &pb.BatchResponse{}
+	start := time.Now()
+	err := peer.GetMany(ctx, req, res)
+	d := time.Since(start)
+	if err != nil {
+		for _, key := range keys {
+			g.fetched(key, peer, d, err)
+		}
+		return nil, nil, err
+	}
+
+	values := make(map[string]ByteView, len(keys))
+	errs := BatchError{}
+	defer func() {
+		for _, key := range keys {
+			g.fetched(key, peer, d, errs[key])
+		}
+	}()
+	for _, entry := range res.GetEntries() {
+		if entry.GetNotFound() {
+			errs[entry.GetKey()] = g.peerNotFound(entry.GetKey(), fromUnixNano(entry.GetExpire()))
+			continue
+		}
+		if entry.GetError() != "" {
+			errs[entry.GetKey()] = fmt.Errorf("%s", entry.GetError())
+			continue
+		}
+		value, err := decodeView(entry.GetValue(), entry.GetEncoding(), fromUnixNano(entry.GetExpire()))
+		if err != nil {
+			errs[entry.GetKey()] = err
+			continue
+		}
+		values[entry.GetKey()] = value
+		g.stats.peerLoads.Add(1)
+		g.stats.peer(peerAddr(peer)).loads.Add(1)
+		if rand.Intn(hotCacheSample) == 0 {
+			g.hotCache.add(entry.GetKey(), value)
+		}
+	}
+	return values, errs, nil
+}
+
+// getManyLocally loads keys with the getter, adding them to the main cache if populate is set
+func (g *Group) getManyLocally(ctx context.Context, keys []string, populate bool) (map[string]ByteView, BatchError) {
+	values := make(map[string]ByteView, len(keys))
+	errs := BatchError{}
+
+	// keys loaded by a call in flight are waited on, the batch loads the rest
+	wait := keys
+	if g.canGetMany() {
+		wait = nil
+		var batch []string
+		finish := make(map[string]func(any, error), len(keys))
+		for _, key := range keys {
+			if f := g.loader.Begin(key); f != nil {
+				batch = append(batch, key)
+				finish[key] = f
+			} else {
+				wait = append(wait, key)
+			}
+		}
+		if len(batch) > 0 {
+			g.batchLoad(batch, populate, values, errs, finish)
+		}
+	}
+
+	// the other keys are loaded one call each, all at once
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, key := range wait {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			viewi, err, _ := g.loader.DoDetached(ctx, key, func(ctx context.Context) (any, error) {
+				g.stats.loadsDeduped.Add(1)
+				if populate {
+					return g.getLocally(ctx, key)
+				}
+				return g.loadLocally(ctx, key)
+			})
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			values[key] = viewi.(ByteView)
+		}(key)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+func (g *Group) canGetMany() bool {
+	switch g.getter.(type) {
+	case ExpireBatchGetter, BatchGetter:
+		return true
+	}
+	return false
+}
+
+// batchLoad loads keys in one call to the getter and hands each result
+// to the callers waiting on the key with finish
+func (g *Group) batchLoad(keys []string, populate bool, values map[string]ByteView, errs BatchError, finish map[string]func(any, error)) {
+	var (
+		got     map[string][]byte
+		expires map[string]time.Time
+		err     error
+	)
+	defer func() {
+		// also reached if the getter panics, the waiters must not hang
+		for _, key := range keys {
+			if v, ok := values[key]; ok {
+				finish[key](v, nil)
+			} else if errs[key] != nil {
+				finish[key](nil, errs[key])
+			} else {
+				finish[key](nil, fmt.Errorf("batch load of %s did not return", key))
+			}
+		}
+	}()
+
+	g.stats.loadsDeduped.Add(int64(len(keys)))
+	start := time.Now()
+	switch getter := g.getter.(type) {
+	case ExpireBatchGetter:
+		got, expires, err = getter.GetManyWithExpire(keys)
+	case BatchGetter:
+		got, err = getter.GetMany(keys)
+	}
+	d := time.Since(start)
+	defer func() {
+		for _, key := range keys {
+			g.loaded(key, d, errs[key])
+		}
+	}()
+	if err != nil {
+		g.stats.localLoadErrs.Add(int64(len(keys)))
+		for _, key := range keys {
+			errs[key] = err
+		}
+		return
+	}
+	for _, key := range keys {
+		bytes, ok := got[key]
+		if !ok {
+			g.stats.localLoadErrs.Add(1)
+			errs[key] = g.notFound(key, populate)
+			continue
+		}
+		g.stats.localLoads.Add(1)
+		value := g.withTTL(ByteView{b: cloneBytes(bytes), e: expires[key]})
+		if populate {
+			g.setCache(key, value)
+		}
+		values[key] = value
+	}
+}
+
+// batchResponse turns the result of GetMany into the entries sent to peers
+func batchResponse(keys []string, values map[string]ByteView, err error, accept []string) *pb.BatchResponse {
+	errs, _ := err.(BatchError)
+	res := &pb.BatchResponse{Entries: make([]*pb.Entry, 0, len(keys))}
+	for _, key := range keys {
+		entry := &pb.Entry{Key: key}
+		var nf *notFoundError
+		if v, ok := values[key]; ok {
+			var err error
+			if entry.Value, entry.Encoding, err = v.encode(accept); err != nil {
+				entry.Value, entry.Error = nil, err.Error()
+			}
+			entry.Expire = toUnixNano(v.Expire())
+		} else if errors.As(errs[key], &nf) {
+			entry.NotFound = true
+			entry.Expire = toUnixNano(nf.expire)
+		} else if e, ok := errs[key]; ok {
+			entry.Error = e.Error()
+		} else if err != nil {
+			entry.Error = err.Error()
+		} else {
+			continue
+		}
+		res.Entries = append(res.Entries, entry)
+	}
+	return res
+}
@@ -0,0 +1,207 @@
+package minicache
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+func TestGetManyLocally(t *testing.T) {
+	var calls [][]string
+	g := NewGroup("batch-local", 2<<10, BatchGetterFunc(func(keys []string) (map[string][]byte, error) {
+		calls = append(calls, keys)
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			if key != "unknow" {
+				values[key] = []byte("db-" + key)
+			}
+		}
+		return values, nil
+	}))
+	defer DestroyGroup("batch-local")
+	g.Get("rocky")
+
+	values, err := g.GetMany([]string{"rocky", "amy", "dim", "amy", "unknow"})
+	errs, ok := err.(BatchError)
+	if !ok || len(errs) != 1 || errs["unknow"] == nil {
+		t.Fatalf("expect unknow to fail, got %v", err)
+	}
+	if len(values) != 3 || values["amy"].String() != "db-amy" || values["rocky"].String() != "db-rocky" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if expect := [][]string{{"rocky"}, {"amy", "dim", "unknow"}}; !reflect.DeepEqual(expect, calls) {
+		t.Fatalf("getter calls %v, expect %v", calls, expect)
+	}
+
+	if _, err := g.GetMany([]string{"amy", "dim"}); err != nil || len(calls) != 2 {
+		t.Fatalf("expect amy and dim cached, err %v, %d calls", err, len(calls))
+	}
+}
+
+func TestGetManyJoinsLoads(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		calls [][]string
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	g := NewGroup("batch-join", 2<<10, ExpireBatchGetterFunc(func(keys []string) (map[string][]byte, map[string]time.Time, error) {
+		lock.Lock()
+		calls = append(calls, keys)
+		lock.Unlock()
+		if keys[0] == "slow" {
+			close(started)
+			<-release
+		}
+		values := make(map[string][]byte)
+		expires := make(map[string]time.Time)
+		for _, key := range keys {
+			values[key] = []byte("db-" + key)
+			expires[key] = expire
+		}
+		return values, expires, nil
+	}))
+	defer DestroyGroup("batch-join")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := g.Get("slow"); err != nil || v.String() != "db-slow" {
+			t.Errorf("unexpected get %s %v", v, err)
+		}
+	}()
+	<-started
+	got := make(chan map[string]ByteView)
+	go func() {
+		values, _ := g.GetMany([]string{"slow", "fast"})
+		got <- values
+	}()
+	for {
+		lock.Lock()
+		n := len(calls)
+		lock.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	values := <-got
+	<-done
+
+	if expect := [][]string{{"slow"}, {"fast"}}; !reflect.DeepEqual(expect, calls) {
+		t.Fatalf("expect the batch to leave out the key in flight, got %v", calls)
+	}
+	if values["slow"].String() != "db-slow" || values["fast"].String() != "db-fast" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if !values["fast"].Expire().Equal(expire) || !values["slow"].Expire().Equal(expire) {
+		t.Fatalf("expect the expiry of the getter, got %v %v", values["fast"].Expire(), values["slow"].Expire())
+	}
+}
+
+func TestGetManyConcurrentLoads(t *testing.T) {
+	// every load waits for the others, one at a time they would never return
+	var entered sync.WaitGroup
+	entered.Add(3)
+	g := NewGroup("batch-concurrent", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		entered.Done()
+		entered.Wait()
+		return []byte("db-" + key), nil
+	}))
+	defer DestroyGroup("batch-concurrent")
+
+	done := make(chan struct{})
+	var (
+		values map[string]ByteView
+		err    error
+	)
+	go func() {
+		defer close(done)
+		values, err = g.GetMany([]string{"a", "b", "c"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expect the keys of a plain Getter loaded concurrently")
+	}
+	if err != nil || len(values) != 3 || values["c"].String() != "db-c" {
+		t.Fatalf("unexpected values %v %v", values, err)
+	}
+}
+
+func TestGetManyFromPeers(t *testing.T) {
+	owner := &fakePeer{values: map[string][]byte{"a": []byte("1"), "b": []byte("2")}}
+	loads := 0
+	g := NewGroup("batch-peers", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("local-" + key), nil
+	}))
+	defer DestroyGroup("batch-peers")
+	g.RegisterPeers(&fakePicker{remote: map[string]bool{"a": true, "b": true, "c": true}, peers: []*fakePeer{owner}})
+
+	values, err := g.GetMany([]string{"a", "b", "c", "mine"})
+	if errs, ok := err.(BatchError); !ok || len(errs) != 1 || errs["c"] == nil {
+		t.Fatalf("expect c to fail on its owner, got %v", err)
+	}
+	if values["a"].String() != "1" || values["b"].String() != "2" || values["mine"].String() != "local-mine" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if len(owner.batches) != 1 || loads != 1 {
+		t.Fatalf("expect one batch to the owner and one local load, got %v and %d", owner.batches, loads)
+	}
+	batch := owner.batches[0]
+	sort.Strings(batch)
+	if !reflect.DeepEqual(batch, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected batch %v", batch)
+	}
+}
+
+func TestHTTPGetMany(t *testing.T) {
+	g := NewGroup("batch-http", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "unknow" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("batch-http")
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	values, errs, err := g.getManyFromPeer(context.Background(), peer, []string{"rocky", "amy", "unknow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["rocky"].String() != "rocky" || values["amy"].String() != "amy" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if len(errs) != 1 || errs["unknow"] == nil {
+		t.Fatalf("expect unknow to fail, got %v", errs)
+	}
+	if s := g.Stats(); s.ServerRequests != 3 {
+		t.Fatalf("expect 3 server requests, got %d", s.ServerRequests)
+	}
+}
+
+func TestBatchResponse(t *testing.T) {
+	values := map[string]ByteView{"a": {b: []byte("1")}}
+	res := batchResponse([]string{"a", "b"}, values, BatchError{"b": fmt.Errorf("b not exist")}, nil)
+	expect := []*pb.Entry{{Key: "a", Value: []byte("1")}, {Key: "b", Error: "b not exist"}}
+	if len(res.GetEntries()) != 2 {
+		t.Fatalf("unexpected entries %v", res.GetEntries())
+	}
+	for i, entry := range res.GetEntries() {
+		if entry.GetKey() != expect[i].GetKey() || string(entry.GetValue()) != string(expect[i].GetValue()) || entry.GetError() != expect[i].GetError() {
+			t.Fatalf("entry %d = %v, expect %v", i, entry, expect[i])
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+	"github.com/qingants/pandora/minirpc"
+)
+
+// a peer failing breakerThreshold times in a row is skipped for a backoff
+// starting at breakerBackoff and doubling up to breakerMaxBackoff while
+// the probe let through after each backoff keeps failing
+var (
+	breakerThreshold  = 3
+	breakerBackoff    = 100 * time.Millisecond
+	breakerMaxBackoff = 30 * time.Second
+)
+
+// ErrPeerUnhealthy is returned for calls to a peer whose breaker is open
+var ErrPeerUnhealthy = errors.New("peer is unhealthy")
+
+// replyError is an error the peer answered with, such as its getter
+// failing, it says nothing about the health of the peer
+type replyError struct {
+	err error
+}
+
+func (e *replyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *replyError) Unwrap() error {
+	return e.err
+}
+
+// peer wraps the PeerGetter of a pool member with a circuit breaker
+type peer struct {
+	PeerGetter
+	addr string
+	load loadTracker // counts the calls in flight if set
+
+	lock      sync.Mutex
+	failures  int
+	backoff   time.Duration
+	openUntil time.Time
+}
+
+func newPeer(addr string, getter PeerGetter) *peer {
+	return &peer{PeerGetter: getter, addr: addr}
+}
+
+func (p *peer) Addr() string {
+	return p.addr
+}
+
+// healthy tells if calls would currently go through
+func (p *peer) healthy() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.failures < breakerThreshold || !time.Now().Before(p.openUntil)
+}
+
+// allow lets a call through unless the breaker is open, once the backoff
+// is over a single probe is let through until its result is known
+func (p *peer) allow() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.failures < breakerThreshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(p.openUntil) {
+		return false
+	}
+	p.openUntil = now.Add(p.backoff)
+	return true
+}
+
+func (p *peer) done(ctx context.Context, err error) {
+	var reply *replyError
+	if err != nil && (ctx.Err() != nil || errors.As(err, &reply)) {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err == nil {
+		p.failures = 0
+		p.backoff = 0
+		return
+	}
+	p.failures++
+	if p.failures < breakerThreshold {
+		return
+	}
+	if p.backoff == 0 {
+		p.backoff = breakerBackoff
+	} else if p.backoff *= 2; p.backoff > breakerMaxBackoff {
+		p.backoff = breakerMaxBackoff
+	}
+	p.openUntil = time.Now().Add(p.backoff)
+}
+
+func (p *peer) call(ctx context.Context, fn func() error) error {
+	if !p.allow() {
+		return fmt.Errorf("%s: %w", p.addr, ErrPeerUnhealthy)
+	}
+	if p.load != nil {
+		p.load.Inc(p.addr)
+		defer p.load.Done(p.addr)
+	}
+	err := fn()
+	p.done(ctx, err)
+	return err
+}
+
+func (p *peer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return p.call(ctx, func() error { return p.PeerGetter.Get(ctx, in, out) })
+}
+
+func (p *peer) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	return p.call(ctx, func() error { return p.PeerGetter.Set(ctx, in, out) })
+}
+
+func (p *peer) Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.Response) error {
+	return p.call(ctx, func() error { return p.PeerGetter.Remove(ctx, in, out) })
+}
+
+func (p *peer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return p.call(ctx, func() error { return p.PeerGetter.GetMany(ctx, in, out) })
+}
+
+// peerAddr names peer in logs and stats
+func peerAddr(peer PeerGetter) string {
+	if p, ok := peer.(interface{ Addr() string }); ok {
+		return p.Addr()
+	}
+	return fmt.Sprintf("%T", peer)
+}
+
+// asReplyError marks errors returned by the minirpc service as replies
+func asReplyError(err error) error {
+	var serverErr minirpc.ServerError
+	if errors.As(err, &serverErr) {
+		return &replyError{err}
+	}
+	return err
+}
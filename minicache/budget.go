@@ -0,0 +1,175 @@
+package minicache
+
+import (
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memorySampleInterval is how often the memory of the process is read
+// for the soft limit of a Budget
+var memorySampleInterval = 100 * time.Millisecond
+
+// Budget is a memory limit shared by every group created with it in its
+// Option. Cache sizes include an estimate of the overhead of each entry;
+// once their total goes over the limit entries are evicted from the group
+// using the most memory relative to its weight.
+type Budget struct {
+	limit     int64
+	softLimit atomic.Int64
+	used      atomic.Int64
+
+	lock   sync.Mutex
+	groups []*Group
+
+	shrinking sync.Mutex
+	sampled   atomic.Int64 // unix nano of the last memory sample
+	excess    atomic.Int64 // bytes the process is over the soft limit
+}
+
+func NewBudget(limit int64) *Budget {
+	return &Budget{limit: limit}
+}
+
+// SetSoftLimit lowers the limit of the budget by as much as the memory
+// of the whole process, as reported by runtime/metrics, goes over bytes.
+// It keeps the caches from pushing the process past its container limit
+// when the rest of the program grows. Zero turns it off
+func (b *Budget) SetSoftLimit(bytes int64) {
+	b.softLimit.Store(bytes)
+	b.sampled.Store(0)
+	b.excess.Store(0)
+}
+
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the estimated bytes taken by the caches of all groups
+func (b *Budget) Used() int64 {
+	return b.used.Load()
+}
+
+func (b *Budget) attach(g *Group) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.groups = append(b.groups, g)
+}
+
+func (b *Budget) detach(g *Group) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, other := range b.groups {
+		if other == g {
+			b.groups = append(b.groups[:i], b.groups[i+1:]...)
+			return
+		}
+	}
+}
+
+// effectiveLimit is the limit less what the process is over the soft limit
+func (b *Budget) effectiveLimit() int64 {
+	soft := b.softLimit.Load()
+	if soft <= 0 {
+		return b.limit
+	}
+	now := time.Now().UnixNano()
+	if last := b.sampled.Load(); now-last >= int64(memorySampleInterval) && b.sampled.CompareAndSwap(last, now) {
+		excess := processMemory() - soft
+		if excess < 0 {
+			excess = 0
+		}
+		b.excess.Store(excess)
+	}
+	return b.limit - b.excess.Load()
+}
+
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// processMemory returns the memory mapped by the Go runtime and not yet
+// returned to the OS, the figure the GOMEMLIMIT of the runtime applies to
+func processMemory() int64 {
+	samples := make([]metrics.Sample, len(memorySamples))
+	copy(samples, memorySamples)
+	metrics.Read(samples)
+	var total, released uint64
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		total = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		released = samples[1].Value.Uint64()
+	}
+	return int64(total - released)
+}
+
+// enforce evicts entries until the budget is back under its limit,
+// callers racing with a running eviction leave it the work
+func (b *Budget) enforce() {
+	if b.used.Load() <= b.effectiveLimit() {
+		return
+	}
+	if !b.shrinking.TryLock() {
+		return
+	}
+	defer b.shrinking.Unlock()
+
+	for b.used.Load() > b.effectiveLimit() {
+		g := b.victim()
+		if g == nil || !g.disuse() {
+			return
+		}
+	}
+}
+
+// victim returns the group using the most memory for its weight
+func (b *Budget) victim() *Group {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var (
+		victim *Group
+		most   float64
+	)
+	for _, g := range b.groups {
+		used := g.mem.used.Load()
+		if used <= 0 {
+			continue
+		}
+		if share := float64(used) / float64(g.mem.weight); victim == nil || share > most {
+			victim, most = g, share
+		}
+	}
+	return victim
+}
+
+// memAccount is the memory used by the caches of a group sharing a Budget
+type memAccount struct {
+	budget *Budget
+	weight int64
+	used   atomic.Int64
+}
+
+func (m *memAccount) charge(delta int64) {
+	if delta != 0 {
+		m.used.Add(delta)
+		m.budget.used.Add(delta)
+	}
+}
+
+// disuse evicts a decoded value or an entry from the hot cache once they
+// hold more than their usual share of the group, from the main cache otherwise
+func (g *Group) disuse() bool {
+	used := g.mem.used.Load()
+	if g.decoded != nil && g.decoded.bytes()*decodedCacheRatio > used && g.decoded.disuse() {
+		return true
+	}
+	hot := g.hotCache.bytes()
+	if hot*hotCacheRatio > used && g.hotCache.disuse() {
+		return true
+	}
+	return g.mainCache.disuse() || g.hotCache.disuse() || (g.decoded != nil && g.decoded.disuse())
+}
@@ -0,0 +1,127 @@
+package minicache
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fillGroup(g *Group, n int) {
+	for i := 0; i < n; i++ {
+		g.Get(fmt.Sprintf("key%d", i))
+	}
+}
+
+func groupBytes(g *Group) int64 {
+	return g.CacheStats(MainCache).Bytes + g.CacheStats(HotCache).Bytes
+}
+
+func TestBudgetOverhead(t *testing.T) {
+	g := NewGroup("budget-overhead", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}))
+	defer DestroyGroup("budget-overhead")
+	g.Get("key")
+	want := int64(len("key") + len("value") + entryOverhead(LRU))
+	if s := g.CacheStats(MainCache); s.Bytes != want {
+		t.Fatalf("expect %d bytes with the entry overhead, got %d", want, s.Bytes)
+	}
+}
+
+func TestBudgetShared(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strings.Repeat("v", 100)), nil
+	})
+	b := NewBudget(16 << 10)
+	g1 := NewGroup("budget-1", 0, getter, &Option{Budget: b})
+	defer DestroyGroup("budget-1")
+	g2 := NewGroup("budget-2", 0, getter, &Option{Budget: b, Policy: ARC})
+	defer DestroyGroup("budget-2")
+
+	fillGroup(g1, 500)
+	fillGroup(g2, 500)
+	if b.Used() > b.Limit() {
+		t.Fatalf("budget used %d over its limit %d", b.Used(), b.Limit())
+	}
+	if used := groupBytes(g1) + groupBytes(g2); used != b.Used() {
+		t.Fatalf("groups hold %d bytes, budget accounts for %d", used, b.Used())
+	}
+	if groupBytes(g2) == 0 {
+		t.Fatalf("budget should be shared, not taken by the first group")
+	}
+
+	g1.Remove("key499")
+	if used := groupBytes(g1) + groupBytes(g2); used != b.Used() {
+		t.Fatalf("remove should release its bytes, groups hold %d bytes, budget accounts for %d", used, b.Used())
+	}
+}
+
+func TestBudgetWeights(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strings.Repeat("v", 100)), nil
+	})
+	b := NewBudget(64 << 10)
+	light := NewGroup("budget-light", 0, getter, &Option{Budget: b})
+	defer DestroyGroup("budget-light")
+	heavy := NewGroup("budget-heavy", 0, getter, &Option{Budget: b, Weight: 3})
+	defer DestroyGroup("budget-heavy")
+
+	for i := 0; i < 2000; i++ {
+		light.Get(fmt.Sprintf("key%d", i))
+		heavy.Get(fmt.Sprintf("key%d", i))
+	}
+	ratio := float64(groupBytes(heavy)) / float64(groupBytes(light))
+	if ratio < 2.5 || ratio > 3.5 {
+		t.Fatalf("expect the heavy group to hold about 3 times the bytes, got %.2f", ratio)
+	}
+}
+
+func TestBudgetSoftLimit(t *testing.T) {
+	defer func(d time.Duration) { memorySampleInterval = d }(memorySampleInterval)
+	memorySampleInterval = 0
+
+	b := NewBudget(1 << 20)
+	g := NewGroup("budget-soft", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &Option{Budget: b})
+	defer DestroyGroup("budget-soft")
+	fillGroup(g, 10)
+	if b.Used() == 0 {
+		t.Fatalf("entries should be kept under the limit")
+	}
+
+	// the runtime alone maps more than the limit, so a soft limit of
+	// one byte takes the whole budget back
+	b.SetSoftLimit(1)
+	g.Get("another")
+	if b.Used() != 0 {
+		t.Fatalf("expect every entry evicted over the soft limit, %d bytes left", b.Used())
+	}
+}
+
+func TestBudgetARCGhosts(t *testing.T) {
+	b := NewBudget(64 << 10)
+	g := NewGroup("budget-arc-ghosts", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &Option{Budget: b, Policy: ARC})
+	defer DestroyGroup("budget-arc-ghosts")
+
+	heap := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	fillGroup(g, 10000)
+	before := heap()
+	// keys evicted into the ghost lists must be forgotten again even
+	// though the group has no cacheBytes of its own
+	for i := 0; i < 100000; i++ {
+		g.Get(fmt.Sprintf("more%d", i))
+	}
+	if grown := int64(heap()) - int64(before); grown > 4<<20 {
+		t.Fatalf("heap grew by %d bytes for a budget of %d", grown, b.Limit())
+	}
+}
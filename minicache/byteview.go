@@ -1,19 +1,78 @@
 package minicache
 
+import (
+	"fmt"
+	"time"
+)
+
 type ByteView struct {
 	b []byte
+	e time.Time
+	// notFound marks a cached ErrNotFound, e is when it is forgotten
+	notFound bool
+	// r is when the value goes stale and is refreshed in the background,
+	// zero means it is only reloaded once expired
+	r time.Time
+	// c is the compressor b is compressed with, n the length of the value
+	c Compressor
+	n int
 }
 
 func (v ByteView) Len() int {
+	if v.c != nil {
+		return v.n
+	}
 	return len(v.b)
 }
 
+// Expire returns the time the value goes stale, zero means it never does
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// ByteSlice returns a copy of the value. Views returned by a Group are
+// not compressed, reading them can not fail
 func (v ByteView) ByteSlice() []byte {
+	if v.c != nil {
+		b, _ := v.value()
+		return b
+	}
 	return cloneBytes(v.b)
 }
 
 func (v ByteView) String() string {
-	return string(v.b)
+	b, _ := v.value()
+	return string(b)
+}
+
+// value returns the value, decompressing it without copying raw bytes
+func (v ByteView) value() ([]byte, error) {
+	if v.c == nil {
+		return v.b, nil
+	}
+	b, err := v.c.Decompress(v.b)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s value: %v", v.c.Name(), err)
+	}
+	return b, nil
+}
+
+// decompressed returns v holding its value uncompressed
+func (v ByteView) decompressed() (ByteView, error) {
+	if v.c == nil {
+		return v, nil
+	}
+	b, err := v.value()
+	if err != nil {
+		return ByteView{}, err
+	}
+	v.b, v.c, v.n = b, nil, 0
+	return v, nil
+}
+
+// size is the bytes v takes in a cache
+func (v ByteView) size() int {
+	return len(v.b)
 }
 
 func cloneBytes(b []byte) []byte {
@@ -2,37 +2,329 @@ package minicache
 
 import (
 	"sync"
+	"time"
+	"unsafe"
 
+	"github.com/qingants/pandora/minicache/arc"
+	"github.com/qingants/pandora/minicache/lfu"
 	"github.com/qingants/pandora/minicache/lru"
+	"github.com/qingants/pandora/minicache/tinylfu"
 )
 
+// purgeInterval is how often expired entries are reclaimed in the background
+var purgeInterval = time.Minute
+
+// EvictionPolicy is the store behind a cache, it decides which entries to
+// drop once the cache goes over its byte budget
+type EvictionPolicy interface {
+	Get(key string) (value lru.Value, ok bool)
+	AddWithExpire(key string, value lru.Value, expire time.Time)
+	Remove(key string)
+	RemoveExpired() int
+	// Disuse evicts the entry the policy would drop first
+	Disuse()
+	// Walk calls fn for every entry in eviction order
+	Walk(fn func(key string, value lru.Value, expire time.Time))
+	Len() int
+	Bytes() int64
+}
+
+type PolicyType int
+
+const (
+	LRU     PolicyType = iota // least recently used
+	LFU                       // least frequently used
+	ARC                       // adaptive replacement cache
+	TinyLFU                   // W-TinyLFU admission in front of a segmented LRU
+)
+
+func newPolicy(t PolicyType, maxBytes int64, onEvicted func(string, lru.Value)) EvictionPolicy {
+	switch t {
+	case LFU:
+		return lfu.New(maxBytes, onEvicted)
+	case ARC:
+		return arc.New(maxBytes, onEvicted)
+	case TinyLFU:
+		return tinylfu.New(maxBytes, onEvicted)
+	default:
+		return lru.New(maxBytes, onEvicted)
+	}
+}
+
+var (
+	_ EvictionPolicy = (*lru.Cache)(nil)
+	_ EvictionPolicy = (*lfu.Cache)(nil)
+	_ EvictionPolicy = (*arc.Cache)(nil)
+	_ EvictionPolicy = (*tinylfu.Cache)(nil)
+)
+
+// entryOverhead estimates the bytes an entry takes besides its key and
+// value: the map slot, the bookkeeping of the policy and the boxed value
+func entryOverhead(t PolicyType) int {
+	const (
+		mapSlot = 32 // key header, pointer and tophash at the average load factor
+		element = 48 // container/list.Element
+	)
+	boxed := int(unsafe.Sizeof(sized{}))
+	switch t {
+	case LFU:
+		// entry and its heap slot
+		return mapSlot + 88 + boxed
+	case ARC:
+		return mapSlot + element + 80 + boxed
+	case TinyLFU:
+		// the count-min sketch is sized up front and left out
+		return mapSlot + element + 72 + boxed
+	default:
+		return mapSlot + element + 56 + boxed
+	}
+}
+
+// sized is what caches store, Len is the compressed size plus the
+// overhead of the entry so the byte budget bounds the memory actually used
+type sized struct {
+	ByteView
+	overhead int
+}
+
+func (v sized) Len() int {
+	return v.ByteView.size() + v.overhead
+}
+
 type cache struct {
 	lock       sync.Mutex
-	lru        *lru.Cache
+	policy     EvictionPolicy
+	policyType PolicyType
 	cacheBytes int64
+	overhead   int         // added to the size of every entry
+	mem        *memAccount // set for groups sharing a Budget
+	purgeOnce  sync.Once
+	done       chan struct{} // closed to stop the purge loop
+	closed     bool          // set once the group is destroyed, adds are dropped
+	reason     EvictReason   // why entries the policy drops now are evicted
+	nget, nhit int64
+	nevict     int64
+	// onEvict is told about the values evicted while the lock was held
+	// once it is released
+	onEvict func(key string, value ByteView, reason EvictReason)
+	pending []evicted
+}
+
+type evicted struct {
+	key    string
+	value  ByteView
+	reason EvictReason
+}
+
+func (c *cache) evicted(key string, value lru.Value) {
+	if c.reason == EvictCapacity || c.reason == EvictBudget {
+		c.nevict++
+	}
+	if v := value.(sized).ByteView; c.onEvict != nil && !v.notFound {
+		c.pending = append(c.pending, evicted{key, v, c.reason})
+	}
+}
+
+// unlock releases the lock and then runs onEvict
+// for the values evicted while it was held
+func (c *cache) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.lock.Unlock()
+	for _, e := range pending {
+		c.onEvict(e.key, e.value, e.reason)
+	}
+}
+
+// track runs fn on the policy and charges the bytes it added or freed
+// to the budget of the group
+func (c *cache) track(fn func()) {
+	if c.mem == nil {
+		fn()
+		return
+	}
+	before := c.policy.Bytes()
+	fn()
+	c.mem.charge(c.policy.Bytes() - before)
 }
 
 func (c *cache) add(key string, value ByteView) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	if c.policy == nil {
+		c.policy = newPolicy(c.policyType, c.cacheBytes, c.evicted)
+	}
+	c.reason = EvictCapacity
+	c.track(func() {
+		c.policy.AddWithExpire(key, sized{value, c.overhead}, value.Expire())
+	})
+	if !value.Expire().IsZero() {
+		c.purgeOnce.Do(func() {
+			c.done = make(chan struct{})
+			go c.purgeLoop(purgeInterval, c.done)
+		})
+	}
+	c.unlock()
 
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	if c.mem != nil {
+		c.mem.budget.enforce()
 	}
-	c.lru.Add(key, value)
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	defer c.unlock()
 
-	if c.lru == nil {
+	c.nget++
+	if c.policy == nil {
 		return
 	}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	var v lru.Value
+	c.reason = EvictExpired
+	c.track(func() {
+		v, ok = c.policy.Get(key)
+	})
+	if ok {
+		c.nhit++
+		return v.(sized).ByteView, ok
 	}
 
 	return
 }
+
+func (c *cache) remove(key string) {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if c.policy == nil {
+		return
+	}
+	c.reason = EvictRemoved
+	c.track(func() {
+		c.policy.Remove(key)
+	})
+}
+
+func (c *cache) removeExpired() int {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if c.policy == nil {
+		return 0
+	}
+	n := 0
+	c.reason = EvictExpired
+	c.track(func() {
+		n = c.policy.RemoveExpired()
+	})
+	return n
+}
+
+// clear drops every entry, they are not counted as evictions
+func (c *cache) clear() {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if c.policy != nil && c.onEvict != nil {
+		c.policy.Walk(func(key string, value lru.Value, expire time.Time) {
+			if v := value.(sized).ByteView; !v.notFound {
+				c.pending = append(c.pending, evicted{key, v, EvictPurged})
+			}
+		})
+	}
+	c.clearLocked()
+}
+
+func (c *cache) clearLocked() {
+	if c.policy == nil {
+		return
+	}
+	if c.mem != nil {
+		c.mem.charge(-c.policy.Bytes())
+	}
+	c.policy = nil
+}
+
+// close drops every entry and stops the purge loop, later adds are ignored
+func (c *cache) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+	c.clearLocked()
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+}
+
+// disuse evicts the entry the policy would drop first,
+// it reports false if there was none
+func (c *cache) disuse() bool {
+	c.lock.Lock()
+	defer c.unlock()
+
+	if c.policy == nil || c.policy.Len() == 0 {
+		return false
+	}
+	c.reason = EvictBudget
+	c.track(c.policy.Disuse)
+	return true
+}
+
+func (c *cache) bytes() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.policy == nil {
+		return 0
+	}
+	return c.policy.Bytes()
+}
+
+// entries returns the unexpired entries in eviction order
+func (c *cache) entries() []snapshotEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.policy == nil {
+		return nil
+	}
+	now := time.Now()
+	entries := make([]snapshotEntry, 0, c.policy.Len())
+	c.policy.Walk(func(key string, value lru.Value, expire time.Time) {
+		if v := value.(sized).ByteView; !v.notFound && (expire.IsZero() || now.Before(expire)) {
+			entries = append(entries, snapshotEntry{key: key, value: v})
+		}
+	})
+	return entries
+}
+
+func (c *cache) purgeLoop(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *cache) stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := CacheStats{Gets: c.nget, Hits: c.nhit, Evictions: c.nevict}
+	if c.policy != nil {
+		s.Bytes = c.policy.Bytes()
+		s.Items = int64(c.policy.Len())
+	}
+	return s
+}
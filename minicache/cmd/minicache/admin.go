@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/qingants/pandora/minicache"
+)
+
+// apiHandler serves the values of the groups to clients on
+// GET /api/<group>/<key>
+func (s *server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.serveValue)
+	return mux
+}
+
+// adminHandler serves the admin endpoints to callers authorized like peers
+//
+//	GET    /admin/stats                 counters of every group
+//	GET    /admin/peers                 current membership
+//	PUT    /admin/peers                 replace it with the JSON list in the body, self is kept
+//	DELETE /admin/keys/<group>/<key>    purge a key from every peer, hot copies included
+func (s *server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/stats", s.serveStats)
+	mux.HandleFunc("/admin/peers", s.servePeers)
+	mux.HandleFunc("/admin/keys/", s.servePurge)
+	return s.authorize(mux)
+}
+
+// authorize requires the client certificate and signature peers need
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.tlsConfig != nil && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		if s.secret != nil {
+			if err := minicache.VerifyRequest(r, s.secret); err != nil {
+				log.Printf("[MiniCache] Rejected %s %s %v", r.Method, r.URL.Path, err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// groupKey splits the rest of the path after prefix into a group and key
+func (s *server) groupKey(w http.ResponseWriter, r *http.Request, prefix string) (*minicache.Group, string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		http.Error(w, "expect "+prefix+"<group>/<key>", http.StatusBadRequest)
+		return nil, "", false
+	}
+	g := s.registry.Get(parts[0])
+	if g == nil {
+		http.Error(w, "no such group: "+parts[0], http.StatusNotFound)
+		return nil, "", false
+	}
+	return g, parts[1], true
+}
+
+func (s *server) serveValue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	g, key, ok := s.groupKey(w, r, "/api/")
+	if !ok {
+		return
+	}
+	view, err := g.GetContext(r.Context(), key)
+	if errors.Is(err, minicache.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(view.ByteSlice())
+}
+
+// GroupStats is the entry of a group returned by /admin/stats
+type GroupStats struct {
+	Stats     minicache.Stats                `json:"stats"`
+	MainCache minicache.CacheStats           `json:"main_cache"`
+	HotCache  minicache.CacheStats           `json:"hot_cache"`
+	Peers     map[string]minicache.PeerStats `json:"peers"`
+}
+
+func (s *server) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	groups := s.registry.Groups()
+	stats := make(map[string]GroupStats, len(groups))
+	for _, g := range groups {
+		stats[g.Name()] = GroupStats{
+			Stats:     g.Stats(),
+			MainCache: g.CacheStats(minicache.MainCache),
+			HotCache:  g.CacheStats(minicache.HotCache),
+			Peers:     g.PeerStats(),
+		}
+	}
+	writeJSON(w, stats)
+}
+
+func (s *server) servePeers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var peers []string
+		if err := json.NewDecoder(r.Body).Decode(&peers); err != nil {
+			http.Error(w, "expect a JSON list of peers: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		peers = s.withSelf(peers)
+		s.pool.Set(peers...)
+		log.Printf("[MiniCache] Peers set to %v", peers)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.pool.Peers())
+}
+
+func (s *server) servePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	g, key, ok := s.groupKey(w, r, "/admin/keys/")
+	if !ok {
+		return
+	}
+	if err := g.InvalidateContext(r.Context(), key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[MiniCache] Failed to write response %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// Config is read from the JSON or YAML file given with -config
+type Config struct {
+	Self   string `json:"self"`   // address peers reach this node at, eg http://10.0.0.1:8001
+	Listen string `json:"listen"` // defaults to the host and port of self
+	API    string `json:"api"`    // address of the front end, off if empty
+	// Admin is the address of the admin endpoints, off if empty. They
+	// take the certificate or signature peers need with tls or secret_file
+	Admin string `json:"admin"`
+
+	Peers     []string `json:"peers"`
+	PeersFile string   `json:"peers_file"` // one peer per line, watched for changes
+	Owners    int      `json:"owners"`     // replicas of each key
+
+	// BudgetBytes is a memory limit shared by all groups, SoftLimitBytes
+	// lowers it once the whole process uses more
+	BudgetBytes    int64 `json:"budget_bytes"`
+	SoftLimitBytes int64 `json:"soft_limit_bytes"`
+
+	// TLS makes peers talk mutual TLS, self and peers are https addresses.
+	// SecretFile holds a key shared by the peers to sign their requests
+	TLS        *TLSConfig `json:"tls"`
+	SecretFile string     `json:"secret_file"`
+
+	ShutdownTimeout Duration      `json:"shutdown_timeout"`
+	Groups          []GroupConfig `json:"groups"`
+}
+
+// TLSConfig names the PEM files of the certificate and key of the node
+// and of the CA signing the certificates of all peers
+type TLSConfig struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+	CA   string `json:"ca"`
+}
+
+type GroupConfig struct {
+	Name              string       `json:"name"`
+	CacheBytes        int64        `json:"cache_bytes"`
+	Policy            string       `json:"policy"` // lru, lfu, arc or tinylfu
+	Shards            int          `json:"shards"`
+	Weight            int          `json:"weight"`
+	NegativeTTL       Duration     `json:"negative_ttl"`
+	SoftTTL           Duration     `json:"soft_ttl"`
+	HardTTL           Duration     `json:"hard_ttl"`
+	Compress          string       `json:"compress"` // flate or gzip
+	CompressThreshold int          `json:"compress_threshold"`
+	SnapshotPath      string       `json:"snapshot_path"`
+	SnapshotInterval  Duration     `json:"snapshot_interval"`
+	Loader            LoaderConfig `json:"loader"`
+}
+
+// LoaderConfig is the backend values are loaded from: an HTTP origin
+// serving keys under URL or a directory with one file per key
+type LoaderConfig struct {
+	Type    string   `json:"type"` // http or dir
+	URL     string   `json:"url"`
+	Dir     string   `json:"dir"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Duration is a time.Duration written as a string such as "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadConfig reads a YAML config from files ending in .yaml or .yml
+// and a JSON one from any other
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("parsing config: %v", err)
+		}
+	}
+	return parseConfig(data)
+}
+
+// yamlToJSON converts a YAML document to JSON so both formats share the
+// field names, defaults and checks of the JSON decoder
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+	return json.Marshal(doc)
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %v", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate checks the config and fills in the defaults
+func (c *Config) validate() error {
+	u, err := url.Parse(c.Self)
+	if c.Self == "" || err != nil || u.Host == "" {
+		return fmt.Errorf("self must be the http address of this node, got %q", c.Self)
+	}
+	if c.Listen == "" {
+		c.Listen = u.Host
+	}
+	if c.TLS != nil {
+		if c.TLS.Cert == "" || c.TLS.Key == "" || c.TLS.CA == "" {
+			return fmt.Errorf("tls needs cert, key and ca")
+		}
+		if u.Scheme != "https" {
+			return fmt.Errorf("self must be an https address with tls, got %q", c.Self)
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = Duration(defaultShutdownTimeout)
+	}
+	if len(c.Groups) == 0 {
+		return fmt.Errorf("no groups configured")
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("group %d has no name", i)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("group %s configured twice", g.Name)
+		}
+		names[g.Name] = true
+		if g.CacheBytes <= 0 && c.BudgetBytes <= 0 {
+			return fmt.Errorf("group %s: cache_bytes is required without budget_bytes", g.Name)
+		}
+		if _, ok := policies[g.Policy]; !ok {
+			return fmt.Errorf("group %s: unknown policy %q", g.Name, g.Policy)
+		}
+		if _, ok := compressors[g.Compress]; !ok {
+			return fmt.Errorf("group %s: unknown compression %q", g.Name, g.Compress)
+		}
+		switch g.Loader.Type {
+		case "http":
+			if u, err := url.Parse(g.Loader.URL); err != nil || u.Host == "" {
+				return fmt.Errorf("group %s: loader url %q is not valid", g.Name, g.Loader.URL)
+			}
+		case "dir":
+			if g.Loader.Dir == "" {
+				return fmt.Errorf("group %s: loader dir is required", g.Name)
+			}
+		default:
+			return fmt.Errorf("group %s: unknown loader type %q", g.Name, g.Loader.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qingants/pandora/minicache"
+)
+
+const defaultLoaderTimeout = 5 * time.Second
+
+var policies = map[string]minicache.PolicyType{
+	"":        minicache.LRU,
+	"lru":     minicache.LRU,
+	"lfu":     minicache.LFU,
+	"arc":     minicache.ARC,
+	"tinylfu": minicache.TinyLFU,
+}
+
+var compressors = map[string]minicache.Compressor{
+	"":      nil,
+	"flate": minicache.Flate,
+	"gzip":  minicache.Gzip,
+}
+
+func newGetter(cfg LoaderConfig) minicache.Getter {
+	if cfg.Type == "dir" {
+		return dirGetter(cfg.Dir)
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultLoaderTimeout
+	}
+	return &originGetter{base: strings.TrimSuffix(cfg.URL, "/") + "/", client: &http.Client{Timeout: timeout}}
+}
+
+// originGetter loads the value of a key from GET base/key,
+// a 404 is reported as minicache.ErrNotFound
+type originGetter struct {
+	base   string
+	client *http.Client
+}
+
+func (o *originGetter) Get(key string) ([]byte, error) {
+	return o.GetWithContext(context.Background(), key)
+}
+
+func (o *originGetter) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.base+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(res.Body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("origin %s: %w", key, minicache.ErrNotFound)
+	default:
+		return nil, fmt.Errorf("origin returned %v for %s", res.Status, key)
+	}
+}
+
+// dirGetter loads the value of a key from the file of that name in the
+// directory, keys can not reach outside of it
+type dirGetter string
+
+func (d dirGetter) Get(key string) ([]byte, error) {
+	if key == "" || strings.Contains(key, "\x00") {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	// cleaning the key as an absolute path drops any .. leading out
+	name := filepath.Join(string(d), filepath.FromSlash(path.Clean("/"+key)))
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("file %s: %w", key, minicache.ErrNotFound)
+	}
+	return b, err
+}
@@ -0,0 +1,59 @@
+// Command minicache runs a cache node configured by a JSON file, or a
+// YAML one if its name ends in .yaml or .yml, see Config for its fields.
+// An example with two groups:
+//
+//	{
+//	  "self": "http://127.0.0.1:8001",
+//	  "api": "127.0.0.1:9999",
+//	  "admin": "127.0.0.1:9998",
+//	  "peers": ["http://127.0.0.1:8002", "http://127.0.0.1:8003"],
+//	  "budget_bytes": 268435456,
+//	  "groups": [
+//	    {"name": "pages", "policy": "tinylfu", "compress": "flate", "soft_ttl": "1m",
+//	     "loader": {"type": "http", "url": "http://origin.internal/pages"}},
+//	    {"name": "assets", "cache_bytes": 67108864,
+//	     "loader": {"type": "dir", "dir": "/srv/assets"}}
+//	  ]
+//	}
+//
+// or in YAML:
+//
+//	self: http://127.0.0.1:8001
+//	peers: [http://127.0.0.1:8002, http://127.0.0.1:8003]
+//	groups:
+//	  - name: pages
+//	    cache_bytes: 67108864
+//	    soft_ttl: 1m
+//	    loader: {type: http, url: http://origin.internal/pages}
+//
+// It stops gracefully on SIGINT or SIGTERM.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	var path string
+	flag.StringVar(&path, "config", "minicache.json", "path of the config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	s, err := newServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = s.run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
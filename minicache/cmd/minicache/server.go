@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/qingants/pandora/minicache"
+)
+
+type server struct {
+	cfg       *Config
+	pool      *minicache.HTTPPool
+	registry  *minicache.Registry
+	tlsConfig *tls.Config // of the peer and admin listeners, nil for plain http
+	secret    []byte      // signs the requests of peers and admins
+	// stops are called on shutdown, in reverse order
+	stops []func()
+}
+
+func newServer(cfg *Config) (*server, error) {
+	s := &server{cfg: cfg, registry: minicache.NewRegistry()}
+
+	var budget *minicache.Budget
+	if cfg.BudgetBytes > 0 {
+		budget = minicache.NewBudget(cfg.BudgetBytes)
+		budget.SetSoftLimit(cfg.SoftLimitBytes)
+	}
+
+	opt := &minicache.PoolOption{Owners: cfg.Owners, Registry: s.registry}
+	if cfg.TLS != nil {
+		config, err := minicache.NewPeerTLSConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA)
+		if err != nil {
+			return nil, err
+		}
+		opt.TLSConfig = config
+	}
+	if cfg.SecretFile != "" {
+		secret, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		if opt.Secret = bytes.TrimSpace(secret); len(opt.Secret) == 0 {
+			return nil, fmt.Errorf("secret file %s is empty", cfg.SecretFile)
+		}
+	}
+	s.tlsConfig, s.secret = opt.TLSConfig, opt.Secret
+	s.pool = minicache.NewHTTPPool(cfg.Self, opt)
+	// destroying the groups saves their last snapshot
+	s.stops = append(s.stops, func() {
+		for _, g := range s.registry.Groups() {
+			s.registry.Destroy(g.Name())
+		}
+	})
+	for _, gc := range cfg.Groups {
+		g, err := s.registry.NewGroup(gc.Name, gc.CacheBytes, newGetter(gc.Loader), &minicache.Option{
+			Policy:            policies[gc.Policy],
+			Shards:            gc.Shards,
+			SnapshotPath:      gc.SnapshotPath,
+			SnapshotInterval:  time.Duration(gc.SnapshotInterval),
+			NegativeTTL:       time.Duration(gc.NegativeTTL),
+			SoftTTL:           time.Duration(gc.SoftTTL),
+			HardTTL:           time.Duration(gc.HardTTL),
+			Budget:            budget,
+			Weight:            gc.Weight,
+			Compressor:        compressors[gc.Compress],
+			CompressThreshold: gc.CompressThreshold,
+		})
+		if err != nil {
+			s.stop()
+			return nil, err
+		}
+		g.RegisterPeers(s.pool)
+	}
+
+	s.pool.Set(s.withSelf(cfg.Peers)...)
+	if cfg.PeersFile != "" {
+		s.stops = append(s.stops, s.pool.Watch(selfWatcher{minicache.NewFileWatcher(cfg.PeersFile, 0), s}))
+	}
+	s.stops = append(s.stops, func() { _ = s.pool.Close() })
+	return s, nil
+}
+
+// run serves peers, the API and the admin endpoints until ctx is done, then drains the
+// in-flight requests for up to the shutdown timeout
+func (s *server) run(ctx context.Context) error {
+	servers := []*http.Server{{Addr: s.cfg.Listen, Handler: s.pool, TLSConfig: s.tlsConfig}}
+	if s.cfg.API != "" {
+		servers = append(servers, &http.Server{Addr: s.cfg.API, Handler: s.apiHandler()})
+	}
+	if s.cfg.Admin != "" {
+		servers = append(servers, &http.Server{Addr: s.cfg.Admin, Handler: s.adminHandler(), TLSConfig: s.tlsConfig})
+	}
+
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		l, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			s.stop()
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	errc := make(chan error, len(servers))
+	for i, srv := range servers {
+		log.Printf("[MiniCache] Listening on %s", listeners[i].Addr())
+		go func(srv *http.Server, l net.Listener) {
+			if srv.TLSConfig != nil {
+				l = tls.NewListener(l, srv.TLSConfig)
+			}
+			if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}(srv, listeners[i])
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Printf("[MiniCache] Shutting down")
+	case err = <-errc:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.ShutdownTimeout))
+	defer cancel()
+	for _, srv := range servers {
+		if serr := srv.Shutdown(shutdownCtx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	s.stop()
+	return err
+}
+
+// withSelf adds this node to peers unless it is listed already
+func (s *server) withSelf(peers []string) []string {
+	for _, p := range peers {
+		if p == s.cfg.Self {
+			return peers
+		}
+	}
+	return append([]string{s.cfg.Self}, peers...)
+}
+
+// selfWatcher keeps this node in the peer lists w reports,
+// as the admin endpoint does for the lists it is given
+type selfWatcher struct {
+	w minicache.PeerWatcher
+	s *server
+}
+
+func (sw selfWatcher) Watch(stop <-chan struct{}, update func(peers []string)) {
+	sw.w.Watch(stop, func(peers []string) {
+		update(sw.s.withSelf(peers))
+	})
+}
+
+func (s *server) stop() {
+	for i := len(s.stops) - 1; i >= 0; i-- {
+		s.stops[i]()
+	}
+	s.stops = nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(`{
+		"self": "http://127.0.0.1:8001",
+		"groups": [{"name": "pages", "cache_bytes": 1024, "soft_ttl": "1m",
+			"loader": {"type": "http", "url": "http://origin/pages"}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:8001" || cfg.ShutdownTimeout != Duration(defaultShutdownTimeout) {
+		t.Fatalf("defaults not applied %+v", cfg)
+	}
+	if time.Duration(cfg.Groups[0].SoftTTL) != time.Minute {
+		t.Fatalf("expect soft_ttl 1m, got %v", time.Duration(cfg.Groups[0].SoftTTL))
+	}
+
+	for _, bad := range []string{
+		`{"groups": [{"name": "a", "cache_bytes": 1, "loader": {"type": "dir", "dir": "/"}}]}`,
+		`{"self": "http://a:1", "groups": []}`,
+		`{"self": "http://a:1", "groups": [{"name": "a", "cache_bytes": 1, "loader": {"type": "ftp"}}]}`,
+		`{"self": "http://a:1", "groups": [{"name": "a", "loader": {"type": "dir", "dir": "/"}}]}`,
+		`{"self": "http://a:1", "groups": [{"name": "a", "cache_bytes": 1, "policy": "fifo", "loader": {"type": "dir", "dir": "/"}}]}`,
+		`{"self": "http://a:1", "tls": {"cert": "c", "key": "k", "ca": "ca"}, "groups": [{"name": "a", "cache_bytes": 1, "loader": {"type": "dir", "dir": "/"}}]}`,
+		`{"self": "http://a:1", "unknown": 1, "groups": [{"name": "a", "cache_bytes": 1, "loader": {"type": "dir", "dir": "/"}}]}`,
+	} {
+		if _, err := parseConfig([]byte(bad)); err == nil {
+			t.Fatalf("expect an error for %s", bad)
+		}
+	}
+}
+
+func TestLoadYAMLConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "minicache.yaml")
+	err := os.WriteFile(path, []byte(`
+self: http://127.0.0.1:8001
+peers: [http://127.0.0.1:8002]
+groups:
+  - name: pages
+    cache_bytes: 1024
+    soft_ttl: 1m
+    loader: {type: http, url: http://origin/pages}
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:8001" || len(cfg.Peers) != 1 || time.Duration(cfg.Groups[0].SoftTTL) != time.Minute {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	if err = os.WriteFile(path, []byte("self: http://a:1\nunknown: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadConfig(path); err == nil {
+		t.Fatalf("expect unknown fields refused in YAML too")
+	}
+}
+
+func TestDirGetter(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g := dirGetter(dir)
+	if b, err := g.Get("a.txt"); err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected value %q %v", b, err)
+	}
+	if _, err := g.Get("missing"); !errors.Is(err, minicache.ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+	if _, err := g.Get("../" + filepath.Base(dir) + "/a.txt"); !errors.Is(err, minicache.ErrNotFound) {
+		t.Fatalf("keys should not leave the directory, got %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pages/home" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<h1>home</h1>"))
+	}))
+	defer origin.Close()
+
+	cfg, err := parseConfig([]byte(`{
+		"self": "http://127.0.0.1:0",
+		"listen": "127.0.0.1:0",
+		"api": "127.0.0.1:0",
+		"groups": [{"name": "server-pages", "cache_bytes": 4096,
+			"loader": {"type": "http", "url": "` + origin.URL + `/pages"}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(s.apiHandler())
+	defer api.Close()
+	admin := httptest.NewServer(s.adminHandler())
+	defer admin.Close()
+
+	get := func(base, path string) (int, string) {
+		res, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if code, body := get(api.URL, "/api/server-pages/home"); code != http.StatusOK || body != "<h1>home</h1>" {
+		t.Fatalf("unexpected response %d %s", code, body)
+	}
+	if code, _ := get(api.URL, "/api/server-pages/gone"); code != http.StatusNotFound {
+		t.Fatalf("expect 404 for a missing key, got %d", code)
+	}
+	if code, _ := get(api.URL, "/api/nope/home"); code != http.StatusNotFound {
+		t.Fatalf("expect 404 for a missing group, got %d", code)
+	}
+
+	code, body := get(admin.URL, "/admin/stats")
+	var stats map[string]GroupStats
+	if err := json.Unmarshal([]byte(body), &stats); code != http.StatusOK || err != nil {
+		t.Fatalf("unexpected stats %d %s", code, body)
+	}
+	if s := stats["server-pages"]; s.Stats.Gets != 2 || s.MainCache.Items != 1 {
+		t.Fatalf("unexpected stats of the group %+v", s)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, admin.URL+"/admin/peers", strings.NewReader(`["http://127.0.0.1:1"]`))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peers []string
+	json.NewDecoder(res.Body).Decode(&peers)
+	res.Body.Close()
+	if len(peers) != 2 {
+		t.Fatalf("expect self and the new peer, got %v", peers)
+	}
+
+	// the purge reaches every peer, not only the owners of the key
+	var purged []string
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			purged = append(purged, r.URL.Path)
+		}
+	}))
+	defer peer.Close()
+	s.pool.Set(cfg.Self, peer.URL)
+
+	req, _ = http.NewRequest(http.MethodDelete, admin.URL+"/admin/keys/server-pages/home", nil)
+	if res, err = http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("purge failed %v %v", res.Status, err)
+	}
+	if len(purged) != 1 || purged[0] != "/_minicache/server-pages/home" {
+		t.Fatalf("expect the key purged on the peer, got %v", purged)
+	}
+	s.pool.Set(cfg.Self)
+	if s := s.registry.Get("server-pages").CacheStats(minicache.MainCache); s.Items != 0 {
+		t.Fatalf("expect the key purged, %d items left", s.Items)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.run(ctx) }()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("expect a clean shutdown, got %v", err)
+	}
+	if s.registry.Get("server-pages") != nil {
+		t.Fatalf("expect the groups destroyed on shutdown")
+	}
+}
+
+func TestPeersFileKeepsSelf(t *testing.T) {
+	dir := t.TempDir()
+	peersFile := filepath.Join(dir, "peers")
+	if err := os.WriteFile(peersFile, []byte("http://127.0.0.1:1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]byte(`{
+		"self": "http://127.0.0.1:0",
+		"peers_file": "` + peersFile + `",
+		"groups": [{"name": "peers-file", "cache_bytes": 4096, "loader": {"type": "dir", "dir": "` + dir + `"}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.stop()
+
+	deadline := time.Now().Add(time.Second)
+	for len(s.pool.Peers()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	peers := s.pool.Peers()
+	if len(peers) != 2 || (peers[0] != cfg.Self && peers[1] != cfg.Self) {
+		t.Fatalf("expect self kept next to the listed peer, got %v", peers)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("shared secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]byte(`{
+		"self": "http://127.0.0.1:0",
+		"secret_file": "` + secretFile + `",
+		"groups": [{"name": "admin-auth", "cache_bytes": 4096, "loader": {"type": "dir", "dir": "` + dir + `"}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.stop()
+	admin := httptest.NewServer(s.adminHandler())
+	defer admin.Close()
+	api := httptest.NewServer(s.apiHandler())
+	defer api.Close()
+
+	put := func(base string, sign bool) int {
+		body := []byte(`["http://127.0.0.1:1"]`)
+		req, _ := http.NewRequest(http.MethodPut, base+"/admin/peers", bytes.NewReader(body))
+		if sign {
+			minicache.SignRequest(req, body, []byte("shared secret"))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := put(api.URL, true); code != http.StatusNotFound {
+		t.Fatalf("expect no admin endpoints on the api address, got %d", code)
+	}
+	if code := put(admin.URL, false); code != http.StatusUnauthorized {
+		t.Fatalf("expect an unsigned admin request refused, got %d", code)
+	}
+	if len(s.pool.Peers()) != 1 {
+		t.Fatalf("expect the membership untouched, got %v", s.pool.Peers())
+	}
+	if code := put(admin.URL, true); code != http.StatusOK || len(s.pool.Peers()) != 2 {
+		t.Fatalf("expect a signed admin request served, got %d %v", code, s.pool.Peers())
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/qingants/pandora/minicache"
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+var errUsage = errors.New("usage")
+
+// cluster holds the flags shared by every subcommand
+type cluster struct {
+	peers      string
+	owners     int
+	basePath   string
+	timeout    time.Duration
+	secretFile string
+	cert       string
+	key        string
+	ca         string
+
+	client *http.Client
+	secret []byte
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *cluster) {
+	c := &cluster{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.peers, "peers", os.Getenv("MINICACHE_PEERS"), "comma separated peers, eg http://10.0.0.1:8001")
+	fs.IntVar(&c.owners, "owners", 1, "replicas of each key, as configured on the nodes")
+	fs.StringVar(&c.basePath, "base", "/_minicache/", "base path the nodes serve peers on")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "timeout of each request")
+	fs.StringVar(&c.secretFile, "secret-file", os.Getenv("MINICACHE_SECRET_FILE"), "file with the key peers sign requests with")
+	fs.StringVar(&c.cert, "cert", "", "certificate to present to peers talking mutual TLS")
+	fs.StringVar(&c.key, "key", "", "key of -cert")
+	fs.StringVar(&c.ca, "ca", "", "CA signing the certificates of the peers")
+	return fs, c
+}
+
+// list returns the peers and sets up the client talking to them
+func (c *cluster) list() ([]string, error) {
+	c.client = http.DefaultClient
+	if c.cert != "" || c.key != "" || c.ca != "" {
+		config, err := minicache.NewPeerTLSConfig(c.cert, c.key, c.ca)
+		if err != nil {
+			return nil, err
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = config
+		c.client = &http.Client{Transport: t}
+	}
+	if c.secretFile != "" {
+		secret, err := os.ReadFile(c.secretFile)
+		if err != nil {
+			return nil, err
+		}
+		c.secret = bytes.TrimSpace(secret)
+	}
+
+	var peers []string
+	for _, p := range strings.Split(c.peers, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			peers = append(peers, strings.TrimSuffix(p, "/"))
+		}
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers, set -peers or MINICACHE_PEERS")
+	}
+	return peers, nil
+}
+
+// pool places keys the way the nodes do, it is never served
+func (c *cluster) pool(peers []string) *minicache.HTTPPool {
+	p := minicache.NewHTTPPool("", &minicache.PoolOption{Owners: c.owners})
+	p.Set(peers...)
+	return p
+}
+
+func (c *cluster) url(peer, group, key string) string {
+	return peer + c.basePath + url.QueryEscape(group) + "/" + url.QueryEscape(key)
+}
+
+func (c *cluster) do(method, uri string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.secret != nil {
+		minicache.SignRequest(req, nil, c.secret)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func runGet(args []string, w io.Writer) error {
+	fs, c := newFlagSet("get")
+	raw := fs.Bool("raw", false, "write the value alone, as it is")
+	from := fs.String("from", "", "ask this peer instead of the owners")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+	group, key := fs.Arg(0), fs.Arg(1)
+	peers, err := c.list()
+	if err != nil {
+		return err
+	}
+
+	owners := c.pool(peers).Owners(key)
+	ask := owners
+	if *from != "" {
+		ask = []string{*from}
+	}
+	var (
+		res    = &pb.Response{}
+		served string
+	)
+	for _, peer := range ask {
+		body, err := c.do(http.MethodGet, c.url(peer, group, key))
+		if err == nil {
+			err = proto.Unmarshal(body, res)
+		}
+		if err == nil {
+			served = peer
+			break
+		}
+		fmt.Fprintf(os.Stderr, "minicachectl: %s: %v\n", peer, err)
+	}
+	if served == "" {
+		return fmt.Errorf("no peer served %s/%s", group, key)
+	}
+
+	if *raw {
+		_, err = w.Write(res.GetValue())
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "group\t%s\n", group)
+	fmt.Fprintf(tw, "key\t%s\n", key)
+	fmt.Fprintf(tw, "owners\t%s\n", strings.Join(owners, ", "))
+	fmt.Fprintf(tw, "served by\t%s\n", served)
+	expire := "never"
+	if res.GetExpire() != 0 {
+		expire = time.Unix(0, res.GetExpire()).Format(time.RFC3339)
+	}
+	fmt.Fprintf(tw, "expire\t%s\n", expire)
+	if res.GetNotFound() {
+		fmt.Fprintf(tw, "value\tnot found\n")
+		return tw.Flush()
+	}
+	fmt.Fprintf(tw, "bytes\t%d\n", len(res.GetValue()))
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+	if v := res.GetValue(); utf8.Valid(v) {
+		fmt.Fprintf(w, "\n%s\n", v)
+	} else {
+		fmt.Fprintf(w, "\n%s", hex.Dump(v))
+	}
+	return nil
+}
+
+func runRing(args []string, w io.Writer) error {
+	fs, c := newFlagSet("ring")
+	keysFile := fs.String("keys", "", "file with one key per line to place, - for stdin")
+	samples := fs.Int("samples", 100000, "synthetic keys placed to estimate the share of each peer")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	peers, err := c.list()
+	if err != nil {
+		return err
+	}
+	pool := c.pool(peers)
+
+	var keys []string
+	if *keysFile != "" {
+		if keys, err = readKeys(*keysFile); err != nil {
+			return err
+		}
+	}
+	share := make(map[string]int, len(peers))
+	for i := 0; i < *samples; i++ {
+		share[pool.Owners("key" + strconv.Itoa(i))[0]]++
+	}
+	placed := make(map[string]int, len(peers))
+	for _, key := range keys {
+		placed[pool.Owners(key)[0]]++
+	}
+
+	nodes := pool.Peers()
+	sort.Strings(nodes)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "PEER\tRING SHARE\t")
+	if len(keys) > 0 {
+		fmt.Fprint(tw, "KEYS\tKEY SHARE\t")
+	}
+	fmt.Fprintln(tw)
+	for _, node := range nodes {
+		fmt.Fprintf(tw, "%s\t%s\t", node, percent(share[node], *samples))
+		if len(keys) > 0 {
+			fmt.Fprintf(tw, "%d\t%s\t", placed[node], percent(placed[node], len(keys)))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func readKeys(path string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// nodeStats are the counters of one group on one peer, read from its metrics
+type nodeStats struct {
+	gets, hits, loads, peerLoads, items, bytes int64
+}
+
+func runStats(args []string, w io.Writer) error {
+	fs, c := newFlagSet("stats")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	peers, err := c.list()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "PEER\tGROUP\tGETS\tHIT RATE\tLOADS\tPEER LOADS\tITEMS\tBYTES\t")
+	var failed int
+	for _, peer := range peers {
+		body, err := c.do(http.MethodGet, peer+"/metrics")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "minicachectl: %s: %v\n", peer, err)
+			failed++
+			continue
+		}
+		stats := parseMetrics(string(body))
+		groups := make([]string, 0, len(stats))
+		for g := range stats {
+			groups = append(groups, g)
+		}
+		sort.Strings(groups)
+		for _, g := range groups {
+			s := stats[g]
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%d\t\n",
+				peer, g, s.gets, percent(int(s.hits), int(s.gets)), s.loads, s.peerLoads, s.items, s.bytes)
+		}
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+	if failed == len(peers) {
+		return fmt.Errorf("no peer answered")
+	}
+	return nil
+}
+
+// parseMetrics reads the counters of each group from the text written
+// by minicache.WriteMetrics
+func parseMetrics(text string) map[string]*nodeStats {
+	stats := make(map[string]*nodeStats)
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		open, end := strings.IndexByte(line, '{'), strings.LastIndexByte(line, '}')
+		if open < 0 || end < open {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(line[end+1:]), 10, 64)
+		if err != nil {
+			continue
+		}
+		group := label(line[open+1:end], "group")
+		s := stats[group]
+		if s == nil {
+			s = &nodeStats{}
+			stats[group] = s
+		}
+		switch line[:open] {
+		case "minicache_gets_total":
+			s.gets = value
+		case "minicache_hits_total":
+			s.hits = value
+		case "minicache_misses_total":
+			s.loads = value
+		case "minicache_peer_loads_total":
+			s.peerLoads = value
+		case "minicache_cache_items":
+			s.items += value
+		case "minicache_cache_bytes":
+			s.bytes += value
+		}
+	}
+	return stats
+}
+
+// label returns the value of name in labels such as group="a",cache="main"
+func label(labels, name string) string {
+	for labels != "" {
+		eq := strings.Index(labels, `="`)
+		if eq < 0 {
+			return ""
+		}
+		key := labels[:eq]
+		var (
+			value strings.Builder
+			i     = eq + 2
+		)
+		for ; i < len(labels) && labels[i] != '"'; i++ {
+			if labels[i] == '\\' && i+1 < len(labels) {
+				i++
+				if labels[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(labels[i])
+		}
+		if key == name {
+			return value.String()
+		}
+		if i < len(labels) {
+			i++
+		}
+		labels = strings.TrimPrefix(labels[i:], ",")
+	}
+	return ""
+}
+
+func runPurge(args []string, w io.Writer) error {
+	fs, c := newFlagSet("purge")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+	group, key := fs.Arg(0), fs.Arg(1)
+	peers, err := c.list()
+	if err != nil {
+		return err
+	}
+
+	// every peer, not only the owners, may hold a copy in its hot cache
+	var failed int
+	for _, peer := range peers {
+		if _, err := c.do(http.MethodDelete, c.url(peer, group, key)); err != nil {
+			fmt.Fprintf(w, "%s\tfailed: %v\n", peer, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "%s\tpurged\n", peer)
+	}
+	if failed > 0 {
+		return fmt.Errorf("purge failed on %d of %d peers", failed, len(peers))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qingants/pandora/minicache"
+)
+
+func TestCommands(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Start()
+	defer srv.Close()
+	pool := minicache.NewHTTPPool(srv.URL)
+	pool.Set(srv.URL)
+	srv.Config.Handler = pool
+
+	g := minicache.NewGroup("ctl", 2<<10, minicache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer minicache.DestroyGroup("ctl")
+	g.RegisterPeers(pool)
+
+	exec := func(args ...string) string {
+		var out bytes.Buffer
+		if err := run(append(args[:1:1], append([]string{"-peers", srv.URL}, args[1:]...)...), &out); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out.String()
+	}
+
+	out := exec("get", "ctl", "rocky")
+	if !strings.Contains(out, "served by  "+srv.URL) || !strings.Contains(out, "value of rocky") {
+		t.Fatalf("unexpected get output\n%s", out)
+	}
+	if out = exec("get", "-raw", "ctl", "rocky"); out != "value of rocky" {
+		t.Fatalf("expect the raw value, got %q", out)
+	}
+
+	if out = exec("stats"); !strings.Contains(out, " ctl") {
+		t.Fatalf("expect the stats of group ctl\n%s", out)
+	}
+
+	exec("purge", "ctl", "rocky")
+	if s := g.CacheStats(minicache.MainCache); s.Items != 0 {
+		t.Fatalf("expect rocky purged, %d items left", s.Items)
+	}
+	g.Get("amy")
+	g.Get("dim")
+	exec("purge", "ctl")
+	if s := g.CacheStats(minicache.MainCache); s.Items != 0 {
+		t.Fatalf("expect the group purged, %d items left", s.Items)
+	}
+}
+
+func TestRing(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"ring", "-peers", "http://a,http://b,http://c", "-samples", "3000"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range []string{"http://a", "http://b", "http://c"} {
+		if !strings.Contains(out.String(), peer) {
+			t.Fatalf("expect the share of %s\n%s", peer, out.String())
+		}
+	}
+}
+
+func TestParseMetrics(t *testing.T) {
+	stats := parseMetrics(`# HELP minicache_gets_total Get requests.
+minicache_gets_total{group="a\"b"} 10
+minicache_hits_total{group="a\"b"} 4
+minicache_cache_items{group="a\"b",cache="main"} 3
+minicache_cache_items{group="a\"b",cache="hot"} 1
+minicache_peer_served_total{group="c",peer="http://x"} 7
+`)
+	if s := stats[`a"b`]; s == nil || s.gets != 10 || s.hits != 4 || s.items != 4 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if label(`peer="http://x",group="c"`, "group") != "c" {
+		t.Fatalf("expect the group label after another one")
+	}
+}
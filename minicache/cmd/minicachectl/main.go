@@ -0,0 +1,60 @@
+// Command minicachectl inspects and manipulates a cluster of minicache
+// nodes talking HTTP. Every subcommand takes the peers of the cluster
+// with -peers or the MINICACHE_PEERS environment variable, comma separated.
+// Clusters signing their requests or talking mutual TLS take -secret-file
+// and -cert, -key and -ca, as set in the config of the nodes.
+//
+//	minicachectl get [-raw] [-from peer] <group> <key>   value of a key and the peers owning it
+//	minicachectl ring [-keys file] [-samples n]          share of the keys owned by each peer
+//	minicachectl stats                                   counters of every group on every peer
+//	minicachectl purge <group> [key]                     drop a key, or the whole group, on every peer
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, w io.Writer) error
+}
+
+var commands = []command{
+	{"get", "[-raw] [-from peer] <group> <key>", runGet},
+	{"ring", "[-keys file] [-samples n]", runRing},
+	{"stats", "", runStats},
+	{"purge", "<group> [key]", runPurge},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: minicachectl <command> [-peers a,b,c] [flags] [args]")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\n", c.name, c.usage)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return errUsage
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:], w)
+		}
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "minicachectl:", err)
+		}
+		os.Exit(2)
+	}
+}
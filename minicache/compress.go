@@ -0,0 +1,172 @@
+package minicache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultCompressThreshold is the smallest value compressed when a
+// Group has a Compressor and no CompressThreshold
+const defaultCompressThreshold = 1 << 10
+
+// Compressor compresses the values of a Group in its main cache. Peers
+// send each other values still compressed when both know the compressor
+// by its Name, so it must be registered the same way on every node
+type Compressor interface {
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+var (
+	Flate Compressor = flateCompressor{}
+	Gzip  Compressor = gzipCompressor{}
+)
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[string]Compressor{
+		Flate.Name(): Flate,
+		Gzip.Name():  Gzip,
+	}
+)
+
+// RegisterCompressor makes c known to peers sending values compressed
+// with it, the Compressor of a Group is registered by NewGroup
+func RegisterCompressor(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[c.Name()] = c
+}
+
+func getCompressor(name string) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return compressors[name]
+}
+
+func compressorNames() []string {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (flateCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compress returns v compressed if it is large enough and shrinks
+func (g *Group) compress(v ByteView) ByteView {
+	if g.compressor == nil || v.c != nil || len(v.b) < g.compressThreshold {
+		return v
+	}
+	b, err := g.compressor.Compress(v.b)
+	if err != nil || len(b) >= len(v.b) {
+		return v
+	}
+	v.b, v.c, v.n = b, g.compressor, len(v.b)
+	return v
+}
+
+// acceptEncoding is what the group asks peers to send values compressed
+// with, nothing unless it compresses values itself
+func (g *Group) acceptEncoding() []string {
+	if g.compressor == nil {
+		return nil
+	}
+	return compressorNames()
+}
+
+// encode returns the bytes of v sent to a peer accepting the compressors
+// in accept and the name of the one they are compressed with
+func (v ByteView) encode(accept []string) ([]byte, string, error) {
+	if v.c != nil {
+		for _, name := range accept {
+			if name == v.c.Name() {
+				return v.b, name, nil
+			}
+		}
+	}
+	b, err := v.value()
+	return b, "", err
+}
+
+// decodeView is the value a peer sent compressed with encoding, it is
+// kept compressed but checked before it is cached
+func decodeView(b []byte, encoding string, expire time.Time) (ByteView, error) {
+	v := ByteView{b: b, e: expire}
+	if encoding == "" {
+		return v, nil
+	}
+	c := getCompressor(encoding)
+	if c == nil {
+		return ByteView{}, fmt.Errorf("unknown encoding %s", encoding)
+	}
+	raw, err := c.Decompress(b)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("decoding %s value: %v", encoding, err)
+	}
+	v.c, v.n = c, len(raw)
+	return v, nil
+}
@@ -0,0 +1,133 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressors(t *testing.T) {
+	value := []byte(strings.Repeat("<li>minicache</li>", 100))
+	for _, c := range []Compressor{Flate, Gzip} {
+		b, err := c.Compress(value)
+		if err != nil || len(b) >= len(value) {
+			t.Fatalf("%s: expect a smaller value, got %d bytes %v", c.Name(), len(b), err)
+		}
+		if raw, err := c.Decompress(b); err != nil || string(raw) != string(value) {
+			t.Fatalf("%s: decompressed value differs %v", c.Name(), err)
+		}
+	}
+}
+
+func TestGroupCompression(t *testing.T) {
+	large := strings.Repeat(`{"name":"minicache"}`, 100)
+	g := NewGroup("compressed", 64<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "small" {
+			return []byte("tiny"), nil
+		}
+		return []byte(large), nil
+	}), &Option{Compressor: Flate, CompressThreshold: 64})
+	defer DestroyGroup("compressed")
+
+	for i := 0; i < 2; i++ {
+		view, err := g.Get("large")
+		if err != nil || view.String() != large || view.Len() != len(large) {
+			t.Fatalf("unexpected value of %d bytes %v", view.Len(), err)
+		}
+	}
+	if v, ok := g.mainCache.get("large"); !ok || v.c != Flate {
+		t.Fatalf("large value should be kept compressed")
+	}
+	if s := g.CacheStats(MainCache); s.Bytes >= int64(len(large)) {
+		t.Fatalf("expect cache bytes counted compressed, got %d for a value of %d", s.Bytes, len(large))
+	}
+
+	g.Get("small")
+	if v, ok := g.mainCache.get("small"); !ok || v.c != nil {
+		t.Fatalf("value under the threshold should be kept raw")
+	}
+}
+
+func TestCompressionOverHTTP(t *testing.T) {
+	large := strings.Repeat("<p>fragment</p>", 200)
+	g := NewGroup("compressed-http", 64<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(large), nil
+	}), &Option{Compressor: Gzip})
+	defer DestroyGroup("compressed-http")
+	g.Get("page")
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	// the peer asking for compressed values gets the cached bytes as they are
+	view, err := g.getFromPeer(context.Background(), peer, "page")
+	if err != nil || view.c != Gzip || view.String() != large {
+		t.Fatalf("expect the value gzip compressed, got %v %v", view.c, err)
+	}
+	if view, err = g.GetFromPeer(peer, "page"); err != nil || view.c != nil || view.String() != large {
+		t.Fatalf("expect the value handed out decompressed, got %v %v", view.c, err)
+	}
+	values, _, err := g.getManyFromPeer(context.Background(), peer, []string{"page"})
+	if err != nil || values["page"].c != Gzip || values["page"].String() != large {
+		t.Fatalf("expect the batch value gzip compressed, got %v", err)
+	}
+
+	// a group not compressing values does not ask for them compressed
+	g.compressor = nil
+	if view, err = g.GetFromPeer(peer, "page"); err != nil || view.c != nil || view.String() != large {
+		t.Fatalf("expect the raw value, got %v %v", view.c, err)
+	}
+}
+
+// corruptCompressor fails to decompress what it compressed
+type corruptCompressor struct{ flateCompressor }
+
+func (corruptCompressor) Name() string { return "corrupt" }
+
+func (corruptCompressor) Decompress(b []byte) ([]byte, error) {
+	return nil, errors.New("bad checksum")
+}
+
+func TestCorruptCompressedValue(t *testing.T) {
+	large := strings.Repeat("<li>minicache</li>", 100)
+	var loads int
+	g := NewGroup("compressed-corrupt", 64<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(large), nil
+	}), &Option{Compressor: corruptCompressor{}})
+	defer DestroyGroup("compressed-corrupt")
+
+	// the value is compressed as it is cached, the first caller has it raw
+	if view, err := g.Get("page"); err != nil || view.String() != large {
+		t.Fatalf("unexpected value %v", err)
+	}
+	if err := g.WriteSnapshot(io.Discard); err == nil {
+		t.Fatalf("expect the snapshot to fail on a corrupt value")
+	}
+	if _, err := g.Get("page"); err == nil {
+		t.Fatalf("expect an error reading a corrupt value")
+	}
+	if view, err := g.Get("page"); err != nil || view.String() != large || loads != 2 {
+		t.Fatalf("expect the corrupt value dropped and loaded again, got %d loads %v", loads, err)
+	}
+	if v, _ := g.mainCache.get("page"); v.String() != "" || v.ByteSlice() != nil {
+		t.Fatalf("expect a corrupt value to read as empty")
+	}
+	if _, err := g.GetMany([]string{"page"}); err == nil {
+		t.Fatalf("expect an error reading a corrupt value in a batch")
+	}
+
+	typed := NewTypedGroup[string]("compressed-corrupt-typed", 64<<10, JSONCodec[string]{}, func(ctx context.Context, key string) (string, error) {
+		return large, nil
+	}, &Option{Compressor: corruptCompressor{}})
+	defer DestroyGroup("compressed-corrupt-typed")
+	if _, err := typed.Get("page"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := typed.Get("page"); err == nil {
+		t.Fatalf("expect the typed decode to fail on a corrupt value")
+	}
+}
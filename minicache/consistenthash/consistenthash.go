@@ -2,8 +2,10 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 type Hash func(data []byte) uint32
@@ -13,6 +15,18 @@ type Map struct {
 	replicas int
 	keys     []int
 	hashMap  map[int]string
+	// nodes maps every node to its weight
+	nodes       map[string]int
+	totalWeight int
+
+	// loadFactor bounds the load of a node to loadFactor times its fair
+	// share, zero turns bounded loads off. Loads change with requests in
+	// flight rather than with the ring, loadLock guards them and the
+	// writes of nodes and totalWeight they are checked against
+	loadFactor float64
+	loadLock   sync.Mutex
+	loads      map[string]int64
+	totalLoad  int64
 }
 
 func New(replicas int, fn Hash) *Map {
@@ -20,6 +34,8 @@ func New(replicas int, fn Hash) *Map {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[int]string),
+		nodes:    make(map[string]int),
+		loads:    make(map[string]int64),
 	}
 	return m
 }
@@ -28,17 +44,86 @@ func NewChecksumIEEE(replicas int) *Map {
 	return New(replicas, crc32.ChecksumIEEE)
 }
 
+// Add puts nodes on the ring with weight 1, nodes already on it are left alone
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
+		m.add(key, 1)
+	}
+	sort.Ints(m.keys)
+}
+
+// AddWeighted puts a node on the ring with weight times the replicas,
+// so it gets about weight times the keys of a node added by Add
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	m.add(key, weight)
+	sort.Ints(m.keys)
+}
+
+func (m *Map) add(key string, weight int) {
+	if _, ok := m.nodes[key]; ok {
+		return
+	}
+	m.loadLock.Lock()
+	m.nodes[key] = weight
+	m.totalWeight += weight
+	m.loadLock.Unlock()
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = key
+	}
+}
+
+// Remove takes nodes off the ring, only the keys they owned move
+func (m *Map) Remove(keys ...string) {
+	removed := make(map[int]bool)
+	for _, key := range keys {
+		weight, ok := m.nodes[key]
+		if !ok {
+			continue
+		}
+		m.loadLock.Lock()
+		delete(m.nodes, key)
+		m.totalWeight -= weight
+		m.totalLoad -= m.loads[key]
+		delete(m.loads, key)
+		m.loadLock.Unlock()
+		for i := 0; i < m.replicas*weight; i++ {
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+			if m.hashMap[hash] == key {
+				delete(m.hashMap, hash)
+				removed[hash] = true
+			}
 		}
 	}
-	sort.Ints(m.keys)
+	if len(removed) == 0 {
+		return
+	}
+
+	hashes := m.keys[:0]
+	for _, hash := range m.keys {
+		if !removed[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	m.keys = hashes
+}
+
+// Nodes returns the nodes on the ring
+func (m *Map) Nodes() []string {
+	nodes := make([]string, 0, len(m.nodes))
+	for node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
 }
 
+// Get returns the node owning key. With bounded loads it is the first node
+// clockwise from key that still has room for one more load
 func (m *Map) Get(key string) string {
 	if len(m.keys) == 0 {
 		return ""
@@ -49,5 +134,125 @@ func (m *Map) Get(key string) string {
 		return m.keys[i] >= hash
 	})
 
+	if m.loadFactor == 0 {
+		return m.hashMap[m.keys[idx%len(m.keys)]]
+	}
+	m.loadLock.Lock()
+	defer m.loadLock.Unlock()
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if m.hasRoom(node) {
+			return node
+		}
+	}
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// GetN walks the ring clockwise from key collecting distinct nodes. With
+// bounded loads the nodes with room for one more load come first, in
+// ring order, and full ones are only returned to make up n
+func (m *Map) GetN(key string, n int) []string {
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	if m.loadFactor != 0 {
+		m.loadLock.Lock()
+		defer m.loadLock.Unlock()
+	}
+	var (
+		nodes = make([]string, 0, n)
+		full  []string
+		seen  = make(map[string]bool, n)
+	)
+	for i := 0; i < len(m.keys) && len(nodes) < n && len(seen) < len(m.nodes); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if m.loadFactor == 0 || m.hasRoom(node) {
+			nodes = append(nodes, node)
+		} else {
+			full = append(full, node)
+		}
+	}
+	for _, node := range full {
+		if len(nodes) == n {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// SetLoadFactor turns on consistent hashing with bounded loads, no node is
+// given more than factor times its weighted share of the load tracked with
+// Inc and Done. A factor of 0 turns it off, others are raised to at least 1
+func (m *Map) SetLoadFactor(factor float64) {
+	if factor != 0 && factor < 1 {
+		factor = 1
+	}
+	m.loadFactor = factor
+}
+
+// Inc records one more unit of load, eg a request in flight, on node.
+// It may be called concurrently with Get and GetN
+func (m *Map) Inc(node string) {
+	m.loadLock.Lock()
+	defer m.loadLock.Unlock()
+	if _, ok := m.nodes[node]; !ok {
+		return
+	}
+	m.loads[node]++
+	m.totalLoad++
+}
+
+// Done records that a unit of load added by Inc on node has finished
+func (m *Map) Done(node string) {
+	m.loadLock.Lock()
+	defer m.loadLock.Unlock()
+	if m.loads[node] <= 0 {
+		return
+	}
+	m.loads[node]--
+	m.totalLoad--
+}
+
+// Load returns the load of node tracked with Inc and Done
+func (m *Map) Load(node string) int64 {
+	m.loadLock.Lock()
+	defer m.loadLock.Unlock()
+	return m.loads[node]
+}
+
+// MaxLoad returns the most load node may take before Get skips it
+func (m *Map) MaxLoad(node string) int64 {
+	m.loadLock.Lock()
+	defer m.loadLock.Unlock()
+	return m.maxLoad(node)
+}
+
+// hasRoom tells if node can take one more load, loadLock is held
+func (m *Map) hasRoom(node string) bool {
+	return m.loads[node]+1 <= m.maxLoad(node)
+}
+
+func (m *Map) maxLoad(node string) int64 {
+	weight, ok := m.nodes[node]
+	if !ok {
+		return 0
+	}
+	if m.loadFactor == 0 {
+		return math.MaxInt64
+	}
+	share := float64(m.totalLoad+1) * float64(weight) / float64(m.totalWeight)
+	return int64(math.Ceil(share * m.loadFactor))
+}
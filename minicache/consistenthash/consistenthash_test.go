@@ -36,3 +36,137 @@ func TestHash(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	hash.Add("6", "4", "2")
+	hash.Add("8", "4")
+	if len(hash.keys) != 12 {
+		t.Fatalf("adding a node twice should be a no-op, got %d keys", len(hash.keys))
+	}
+
+	hash.Remove("8", "9")
+
+	testCase := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+	for k, v := range testCase {
+		if hash.Get(k) != v {
+			t.Errorf("asking for %s, should have yielded %s", k, v)
+		}
+	}
+	if nodes := hash.Nodes(); len(nodes) != 3 || len(hash.keys) != 9 {
+		t.Fatalf("unexpected nodes %v with %d keys", nodes, len(hash.keys))
+	}
+
+	hash.Remove("6", "4", "2")
+	if hash.Get("2") != "" {
+		t.Fatalf("empty ring should yield nothing")
+	}
+}
+
+func distribution(m *Map, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[m.Get("key"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+func TestDistribution(t *testing.T) {
+	const keys = 100000
+	hash := NewChecksumIEEE(100)
+	for i := 0; i < 8; i++ {
+		hash.Add("node" + strconv.Itoa(i))
+	}
+
+	counts := distribution(hash, keys)
+	fair := float64(keys) / 8
+	for node, n := range counts {
+		skew := float64(n)/fair - 1
+		t.Logf("%s: %d keys, skew %+.3f", node, n, skew)
+		if skew > 0.25 || skew < -0.25 {
+			t.Errorf("%s got %d keys, fair share is %.0f", node, n, fair)
+		}
+	}
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	const keys = 100000
+	hash := NewChecksumIEEE(100)
+	hash.AddWeighted("small", 1)
+	hash.AddWeighted("medium", 2)
+	hash.AddWeighted("large", 4)
+
+	counts := distribution(hash, keys)
+	weights := map[string]int{"small": 1, "medium": 2, "large": 4}
+	for node, weight := range weights {
+		fair := float64(keys) * float64(weight) / 7
+		skew := float64(counts[node])/fair - 1
+		t.Logf("%s: %d keys, skew %+.3f", node, counts[node], skew)
+		if skew > 0.25 || skew < -0.25 {
+			t.Errorf("%s got %d keys, fair share is %.0f", node, counts[node], fair)
+		}
+	}
+
+	hash.Remove("large")
+	if len(hash.keys) != 300 || hash.totalWeight != 3 {
+		t.Fatalf("removing a weighted node left %d keys", len(hash.keys))
+	}
+}
+
+func TestBoundedLoads(t *testing.T) {
+	const keys = 10000
+	hash := NewChecksumIEEE(10)
+	for i := 0; i < 5; i++ {
+		hash.Add("node" + strconv.Itoa(i))
+	}
+	hash.SetLoadFactor(1.25)
+
+	// a skewed workload, a third of the requests are for the same key
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		if i%3 == 0 {
+			key = "hot"
+		}
+		hash.Inc(hash.Get(key))
+	}
+
+	limit := int64(float64(keys)/5*1.25) + 1
+	for i := 0; i < 5; i++ {
+		node := "node" + strconv.Itoa(i)
+		t.Logf("%s: load %d", node, hash.Load(node))
+		if hash.Load(node) > limit {
+			t.Errorf("%s has load %d over the bound %d", node, hash.Load(node), limit)
+		}
+	}
+
+	// replicas skip full nodes too but still make up n
+	owners := hash.GetN("hot", 2)
+	if len(owners) != 2 || hash.Load(owners[0])+1 > hash.MaxLoad(owners[0]) {
+		t.Fatalf("expect the first replica to have room, got %v", owners)
+	}
+	if all := hash.GetN("hot", 5); len(all) != 5 {
+		t.Fatalf("expect full nodes to make up n, got %v", all)
+	}
+
+	for i := 0; i < 5; i++ {
+		node := "node" + strconv.Itoa(i)
+		for hash.Load(node) > 0 {
+			hash.Done(node)
+		}
+	}
+	hash.SetLoadFactor(0)
+	ring := NewChecksumIEEE(10)
+	ring.Add(hash.Nodes()...)
+	if hash.totalLoad != 0 || hash.Get("hot") != ring.Get("hot") {
+		t.Fatalf("unbounded lookups should follow the ring")
+	}
+}
@@ -0,0 +1,87 @@
+package consistenthash
+
+import "sort"
+
+// Jump is jump consistent hashing over nodes numbered in the order they
+// were added. It needs no memory beyond the node list and spreads keys
+// evenly, but only adding or removing the last node is cheap, removing
+// any other renumbers the nodes after it and moves their keys
+type Jump struct {
+	hash  Hash64
+	nodes []string
+}
+
+// NewJump returns an empty Jump, a nil fn hashes with 64 bit FNV-1a
+func NewJump(fn Hash64) *Jump {
+	if fn == nil {
+		fn = fnv64a
+	}
+	return &Jump{hash: fn}
+}
+
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		if j.index(node) < 0 {
+			j.nodes = append(j.nodes, node)
+		}
+	}
+}
+
+func (j *Jump) Remove(nodes ...string) {
+	for _, node := range nodes {
+		if i := j.index(node); i >= 0 {
+			j.nodes = append(j.nodes[:i], j.nodes[i+1:]...)
+		}
+	}
+}
+
+func (j *Jump) index(node string) int {
+	for i, n := range j.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jumpHash(j.hash([]byte(key)), len(j.nodes))]
+}
+
+// GetN returns the owner of key followed by the nodes numbered after it
+func (j *Jump) GetN(key string, n int) []string {
+	if n > len(j.nodes) {
+		n = len(j.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	idx := jumpHash(j.hash([]byte(key)), len(j.nodes))
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = j.nodes[(idx+i)%len(j.nodes)]
+	}
+	return nodes
+}
+
+func (j *Jump) Nodes() []string {
+	nodes := append([]string(nil), j.nodes...)
+	sort.Strings(nodes)
+	return nodes
+}
+
+// jumpHash is from "A Fast, Minimal Memory, Consistent Hash Algorithm"
+// by Lamping and Veach
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
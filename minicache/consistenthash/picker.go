@@ -0,0 +1,28 @@
+package consistenthash
+
+import "hash/fnv"
+
+// Picker places keys on a set of nodes, Map, Rendezvous and Jump implement it
+type Picker interface {
+	Add(nodes ...string)
+	Remove(nodes ...string)
+	// Get returns the node owning key, or "" when there are no nodes
+	Get(key string) string
+	// GetN returns up to n distinct nodes for key, the owner first
+	GetN(key string, n int) []string
+	Nodes() []string
+}
+
+var (
+	_ Picker = (*Map)(nil)
+	_ Picker = (*Rendezvous)(nil)
+	_ Picker = (*Jump)(nil)
+)
+
+type Hash64 func(data []byte) uint64
+
+func fnv64a(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,138 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+var pickers = []struct {
+	name string
+	new  func() Picker
+}{
+	{"ring", func() Picker { return NewChecksumIEEE(50) }},
+	{"rendezvous", func() Picker { return NewRendezvous(nil) }},
+	{"jump", func() Picker { return NewJump(nil) }},
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = "node" + strconv.Itoa(i)
+	}
+	return nodes
+}
+
+// remapped returns the share of keys whose owner differs after change
+func remapped(p Picker, keys int, change func(Picker)) float64 {
+	before := make([]string, keys)
+	for i := range before {
+		before[i] = p.Get("key" + strconv.Itoa(i))
+	}
+	change(p)
+	moved := 0
+	for i, owner := range before {
+		if p.Get("key"+strconv.Itoa(i)) != owner {
+			moved++
+		}
+	}
+	return float64(moved) / float64(keys)
+}
+
+func TestPickers(t *testing.T) {
+	const keys = 20000
+	for _, tc := range pickers {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.new()
+			if p.Get("key") != "" {
+				t.Fatalf("empty picker should yield nothing")
+			}
+
+			p.Add(nodeNames(10)...)
+			p.Add("node0")
+			if len(p.Nodes()) != 10 {
+				t.Fatalf("unexpected nodes %v", p.Nodes())
+			}
+			counts := make(map[string]int)
+			for i := 0; i < keys; i++ {
+				counts[p.Get("key"+strconv.Itoa(i))]++
+			}
+			for node, n := range counts {
+				if n < keys/10/2 || n > keys/10*2 {
+					t.Errorf("%s got %d of %d keys", node, n, keys)
+				}
+			}
+
+			// adding the 11th node should move about 1/11 of the keys
+			moved := remapped(p, keys, func(p Picker) { p.Add("node10") })
+			if moved > 0.2 {
+				t.Errorf("adding a node moved %.3f of the keys", moved)
+			}
+			moved = remapped(p, keys, func(p Picker) { p.Remove("node10") })
+			if moved > 0.2 {
+				t.Errorf("removing a node moved %.3f of the keys", moved)
+			}
+		})
+	}
+}
+
+func BenchmarkPickerGet(b *testing.B) {
+	for _, tc := range pickers {
+		for _, n := range []int{8, 64, 512} {
+			b.Run(tc.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				p := tc.new()
+				p.Add(nodeNames(n)...)
+				keys := make([]string, 1024)
+				for i := range keys {
+					keys[i] = "key" + strconv.Itoa(i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.Get(keys[i%len(keys)])
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkPickerRemap reports the share of keys moved by adding a node,
+// the ideal is 1/(n+1)
+func BenchmarkPickerRemap(b *testing.B) {
+	for _, tc := range pickers {
+		for _, n := range []int{8, 64} {
+			b.Run(tc.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				var moved float64
+				for i := 0; i < b.N; i++ {
+					p := tc.new()
+					p.Add(nodeNames(n)...)
+					moved += remapped(p, 10000, func(p Picker) { p.Add("extra") })
+				}
+				b.ReportMetric(moved/float64(b.N), "moved/op")
+			})
+		}
+	}
+}
+
+func TestPickersGetN(t *testing.T) {
+	for _, tc := range pickers {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.new()
+			if p.GetN("key", 3) != nil {
+				t.Fatalf("empty picker should yield nothing")
+			}
+			p.Add(nodeNames(5)...)
+			for i := 0; i < 100; i++ {
+				key := "key" + strconv.Itoa(i)
+				nodes := p.GetN(key, 3)
+				if len(nodes) != 3 || nodes[0] != p.Get(key) {
+					t.Fatalf("GetN(%s) = %v, owner is %s", key, nodes, p.Get(key))
+				}
+				if nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+					t.Fatalf("GetN(%s) = %v has duplicates", key, nodes)
+				}
+			}
+			if len(p.GetN("key", 10)) != 5 {
+				t.Fatalf("GetN should stop at the number of nodes")
+			}
+		})
+	}
+}
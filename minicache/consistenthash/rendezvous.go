@@ -0,0 +1,104 @@
+package consistenthash
+
+import "sort"
+
+// Rendezvous is highest random weight hashing, a key goes to the node
+// scoring highest for it. Lookups cost O(nodes) but there is no ring to
+// keep and removing a node only moves the keys it owned
+type Rendezvous struct {
+	hash   Hash64
+	nodes  []string
+	hashes []uint64
+}
+
+// NewRendezvous returns an empty Rendezvous, a nil fn hashes with 64 bit FNV-1a
+func NewRendezvous(fn Hash64) *Rendezvous {
+	if fn == nil {
+		fn = fnv64a
+	}
+	return &Rendezvous{hash: fn}
+}
+
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		if r.index(node) < 0 {
+			r.nodes = append(r.nodes, node)
+			r.hashes = append(r.hashes, r.hash([]byte(node)))
+		}
+	}
+}
+
+func (r *Rendezvous) Remove(nodes ...string) {
+	for _, node := range nodes {
+		if i := r.index(node); i >= 0 {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			r.hashes = append(r.hashes[:i], r.hashes[i+1:]...)
+		}
+	}
+}
+
+func (r *Rendezvous) index(node string) int {
+	for i, n := range r.nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Rendezvous) Get(key string) string {
+	var (
+		best  string
+		score uint64
+	)
+	hash := r.hash([]byte(key))
+	for i, node := range r.nodes {
+		if s := mix64(hash ^ r.hashes[i]); best == "" || s > score {
+			best, score = node, s
+		}
+	}
+	return best
+}
+
+// GetN returns the n nodes scoring highest for key, best first
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	hash := r.hash([]byte(key))
+	scores := make([]uint64, len(r.nodes))
+	order := make([]int, len(r.nodes))
+	for i := range r.nodes {
+		scores[i] = mix64(hash ^ r.hashes[i])
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = r.nodes[order[i]]
+	}
+	return nodes
+}
+
+// mix64 is the splitmix64 finalizer, it scores a node and key pair
+// without hashing their concatenation
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (r *Rendezvous) Nodes() []string {
+	nodes := append([]string(nil), r.nodes...)
+	sort.Strings(nodes)
+	return nodes
+}
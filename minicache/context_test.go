@@ -0,0 +1,164 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetContextDeadline(t *testing.T) {
+	g := NewGroup("context", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return []byte(key), nil
+		}
+	}))
+	defer DestroyGroup("context")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.GetContext(ctx, "rocky"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("loader did not stop at the deadline")
+	}
+}
+
+func TestGetContextWaiter(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("context-waiter", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("context-waiter")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if view, err := g.Get("amy"); err != nil || view.String() != "amy" {
+			t.Errorf("leader got %s, %v", view, err)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "amy"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiter should give up at its deadline, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestHTTPGetterContext(t *testing.T) {
+	g := NewGroup("context-http", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("context-http")
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	if _, err := g.getFromPeer(ctx, peer, "dim"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect the peer request to time out, got %v", err)
+	}
+}
+
+func TestGetContextLeaderCanceled(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("context-leader", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		select {
+		case <-release:
+			return []byte(key), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	defer DestroyGroup("context-leader")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := g.GetContext(ctx, "ada")
+		leader <- err
+	}()
+	waiter := make(chan string, 1)
+	go func() {
+		view, err := g.Get("ada")
+		if err != nil {
+			t.Errorf("waiter failed with the leader %v", err)
+		}
+		waiter <- view.String()
+	}()
+	for g.Stats().Loads < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect the leader to give up, got %v", err)
+	}
+	close(release)
+	if v := <-waiter; v != "ada" {
+		t.Fatalf("expect the waiter to get the value, got %q", v)
+	}
+}
+
+func TestExpireGetterWithContext(t *testing.T) {
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	g := NewGroup("context-expire", 2<<10, ExpireGetterWithContextFunc(func(ctx context.Context, key string) ([]byte, time.Time, error) {
+		if ctx.Value(ctxKey{}) != "traced" {
+			t.Errorf("expect the values of the caller's context")
+		}
+		return []byte(key), expire, nil
+	}))
+	defer DestroyGroup("context-expire")
+	view, err := g.GetContext(context.WithValue(context.Background(), ctxKey{}, "traced"), "ada")
+	if err != nil || !view.Expire().Equal(expire) {
+		t.Fatalf("expect the expiry of the getter, got %v %v", view.Expire(), err)
+	}
+}
+
+type ctxKey struct{}
+
+func TestHTTPPoolTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	pool := NewHTTPPool("self", &PoolOption{Timeout: 20 * time.Millisecond})
+	pool.Set(srv.URL)
+	peer, ok := pool.PickPeer("dim")
+	if !ok {
+		t.Fatalf("expect the peer to own every key")
+	}
+	group, _ := NewRegistry().NewGroup("timeout", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	if _, err := group.GetFromPeer(peer, "dim"); err == nil {
+		t.Fatalf("expect a dead peer to time out without a deadline from the caller")
+	}
+}
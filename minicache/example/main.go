@@ -4,9 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/qingants/pandora/minicache"
+	"github.com/qingants/pandora/minirpc"
 )
 
 var db = map[string]string{
@@ -34,6 +37,22 @@ func startCacheServer(addr string, addrs []string, mini *minicache.Group) {
 	log.Fatal(http.ListenAndServe(addr[7:], peers))
 }
 
+func startRPCCacheServer(addr string, addrs []string, mini *minicache.Group) {
+	server := minirpc.NewServer()
+	peers, err := minicache.NewRPCPool(addr, server, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	peers.Set(addrs...)
+	mini.RegisterPeers(peers)
+	l, err := net.Listen("tcp", strings.TrimPrefix(addr, "tcp@"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("minicache rpc is running at, ", addr)
+	server.Accept(l)
+}
+
 func startAPIServer(apiAddr string, mini *minicache.Group) {
 	http.Handle("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
@@ -51,10 +70,11 @@ func startAPIServer(apiAddr string, mini *minicache.Group) {
 
 func main() {
 	var port int
-	var api bool
+	var api, rpc bool
 
 	flag.IntVar(&port, "port", 8001, "minicache server port")
 	flag.BoolVar(&api, "api", false, "start a api server")
+	flag.BoolVar(&rpc, "rpc", false, "talk to peers over minirpc instead of http")
 	flag.Parse()
 
 	apiAddr := "http://127.0.0.1:9999"
@@ -74,5 +94,12 @@ func main() {
 		go startAPIServer(apiAddr, mini)
 	}
 
+	if rpc {
+		for i, addr := range addrs {
+			addrs[i] = "tcp@" + strings.TrimPrefix(addr, "http://")
+		}
+		startRPCCacheServer("tcp@"+strings.TrimPrefix(addrMap[port], "http://"), addrs, mini)
+		return
+	}
 	startCacheServer(addrMap[port], []string(addrs), mini)
 }
@@ -1,10 +1,15 @@
 package minicache
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
 )
 
 func TestGetterFunc_Get(t *testing.T) {
@@ -62,6 +67,7 @@ func TestGet(t *testing.T) {
 		}
 		return nil, fmt.Errorf("%s not exist", key)
 	}))
+	defer DestroyGroup("name")
 
 	for k, v := range db {
 		if view, err := g.Get(k); err != nil || view.String() != v {
@@ -94,6 +100,7 @@ func TestGetGroup(t *testing.T) {
 	NewGroup(groupName, 2<<10, GetterFunc(func(key string) (bytes []byte, err error) {
 		return
 	}))
+	defer DestroyGroup(groupName)
 	if group := GetGroup(groupName); group == nil || group.name != groupName {
 		t.Fatalf("group name %v not exists", groupName)
 	}
@@ -101,3 +108,226 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("expect nil, but got %v", group.name)
 	}
 }
+
+func TestGetExpire(t *testing.T) {
+	loads := 0
+	g := NewGroup("expire", 2<<10, ExpireGetterFunc(func(key string) ([]byte, time.Time, error) {
+		loads++
+		return []byte(fmt.Sprintf("%s-%d", key, loads)), time.Now().Add(50 * time.Millisecond), nil
+	}))
+	defer DestroyGroup("expire")
+
+	if view, err := g.Get("rocky"); err != nil || view.String() != "rocky-1" {
+		t.Fatalf("first get rocky = %s, %v", view, err)
+	}
+	if view, _ := g.Get("rocky"); view.String() != "rocky-1" || loads != 1 {
+		t.Fatalf("expect cached rocky-1, got %s after %d loads", view, loads)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if view, _ := g.Get("rocky"); view.String() != "rocky-2" || loads != 2 {
+		t.Fatalf("expect reloaded rocky-2, got %s after %d loads", view, loads)
+	}
+}
+
+func TestGetFromPeerExpire(t *testing.T) {
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	g := NewGroup("peer-expire", 2<<10, ExpireGetterFunc(func(key string) ([]byte, time.Time, error) {
+		return []byte(key), expire, nil
+	}))
+	defer DestroyGroup("peer-expire")
+
+	pool := NewHTTPPool("self")
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	view, err := g.GetFromPeer(&httpGetter{baseURL: srv.URL + defaultBasePath}, "amy")
+	if err != nil || view.String() != "amy" {
+		t.Fatalf("get amy from peer = %s, %v", view, err)
+	}
+	if !view.Expire().Equal(expire) {
+		t.Fatalf("peer expire %v, want %v", view.Expire(), expire)
+	}
+}
+
+type fakePeer struct {
+	values  map[string][]byte
+	removed []string
+	batches [][]string
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	v, ok := p.values[in.GetKey()]
+	if !ok {
+		return fmt.Errorf("%s not exist", in.GetKey())
+	}
+	out.Value = v
+	return nil
+}
+
+func (p *fakePeer) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	p.values[in.GetKey()] = in.GetValue()
+	return nil
+}
+
+func (p *fakePeer) Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.Response) error {
+	delete(p.values, in.GetKey())
+	p.removed = append(p.removed, in.GetKey())
+	return nil
+}
+
+func (p *fakePeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.batches = append(p.batches, in.GetKeys())
+	for _, key := range in.GetKeys() {
+		entry := &pb.Entry{Key: key}
+		if v, ok := p.values[key]; ok {
+			entry.Value = v
+		} else {
+			entry.Error = key + " not exist"
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return nil
+}
+
+// fakePicker owns every key in remote, the rest are local
+type fakePicker struct {
+	remote map[string]bool
+	peers  []*fakePeer
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if p.remote[key] {
+		return p.peers[0], true
+	}
+	return nil, false
+}
+
+func (p *fakePicker) GetAll() []PeerGetter {
+	getters := make([]PeerGetter, 0, len(p.peers))
+	for _, peer := range p.peers {
+		getters = append(getters, peer)
+	}
+	return getters
+}
+
+func TestSetRemove(t *testing.T) {
+	owner := &fakePeer{values: map[string][]byte{}}
+	other := &fakePeer{values: map[string][]byte{}}
+	g := NewGroup("set-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	}))
+	defer DestroyGroup("set-remove")
+	g.RegisterPeers(&fakePicker{remote: map[string]bool{"remote": true}, peers: []*fakePeer{owner, other}})
+
+	if err := g.Set("local", []byte("pushed"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := g.Get("local"); view.String() != "pushed" {
+		t.Fatalf("expect pushed value, got %s", view)
+	}
+
+	g.setCache("remote", ByteView{b: []byte("stale")})
+	if err := g.Set("remote", []byte("fresh"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if string(owner.values["remote"]) != "fresh" {
+		t.Fatalf("set not routed to owner, got %s", owner.values["remote"])
+	}
+	if _, ok := g.mainCache.get("remote"); ok {
+		t.Fatalf("local copy of remote should be dropped")
+	}
+
+	if err := g.Remove("remote"); err != nil || len(owner.removed) != 1 || len(other.removed) != 0 {
+		t.Fatalf("remove should only reach the owner, err %v", err)
+	}
+	if err := g.Remove("local"); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := g.Get("local"); view.String() != "db-local" {
+		t.Fatalf("expect reload after remove, got %s", view)
+	}
+
+	if err := g.Invalidate("local"); err != nil {
+		t.Fatal(err)
+	}
+	if len(owner.removed) != 2 || len(other.removed) != 1 {
+		t.Fatalf("invalidate should reach every peer, got %v %v", owner.removed, other.removed)
+	}
+	if _, ok := g.mainCache.get("local"); ok {
+		t.Fatalf("invalidate should drop the local copy")
+	}
+}
+
+func TestHTTPSetRemove(t *testing.T) {
+	g := NewGroup("http-set-remove", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	defer DestroyGroup("http-set-remove")
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	req := &pb.SetRequest{Group: g.name, Key: "yoyo", Value: []byte("zhangyao"), Expire: expire.UnixNano()}
+	if err := peer.Set(context.Background(), req, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	view, err := g.GetFromPeer(peer, "yoyo")
+	if err != nil || view.String() != "zhangyao" || !view.Expire().Equal(expire) {
+		t.Fatalf("get yoyo after set = %s %v, %v", view, view.Expire(), err)
+	}
+
+	if err := peer.Remove(context.Background(), &pb.RemoveRequest{Group: g.name, Key: "yoyo"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GetFromPeer(peer, "yoyo"); err == nil {
+		t.Fatalf("expect yoyo removed")
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	owner := &fakePeer{values: map[string][]byte{}}
+	remote := map[string]bool{}
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner.values[key] = []byte("value")
+		remote[key] = true
+	}
+	g := NewGroup("hot", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s is not local", key)
+	}))
+	defer DestroyGroup("hot")
+	g.RegisterPeers(&fakePicker{remote: remote, peers: []*fakePeer{owner}})
+
+	for key := range remote {
+		if _, err := g.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hot := g.CacheStats(HotCache)
+	if hot.Items == 0 || hot.Items == 500 {
+		t.Fatalf("expect a sample of peer values in the hot cache, got %d", hot.Items)
+	}
+	if main := g.CacheStats(MainCache); main.Items != 0 {
+		t.Fatalf("peer values should not fill the main cache, got %d", main.Items)
+	}
+
+	for key := range remote {
+		g.Get(key)
+	}
+	stats := g.Stats()
+	if stats.HotCacheHits != hot.Items || stats.PeerLoads != 1000-hot.Items {
+		t.Fatalf("unexpected stats %+v with %d hot items", stats, hot.Items)
+	}
+}
+
+func TestHotCacheBytes(t *testing.T) {
+	g := NewGroup("hot-bytes", 800, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("hot-bytes")
+	if g.hotCache.cacheBytes != 100 || g.mainCache.shards[0].cacheBytes != 700 {
+		t.Fatalf("hot cache budget %d, main %d", g.hotCache.cacheBytes, g.mainCache.shards[0].cacheBytes)
+	}
+}
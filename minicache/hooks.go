@@ -0,0 +1,90 @@
+package minicache
+
+import "time"
+
+// EvictReason tells why an entry left a cache
+type EvictReason int
+
+const (
+	// EvictCapacity entries were pushed out by the byte limit of the cache
+	EvictCapacity EvictReason = iota + 1
+	// EvictBudget entries were dropped to bring a shared Budget under its limit
+	EvictBudget
+	// EvictExpired entries were reclaimed after their expire time
+	EvictExpired
+	// EvictRemoved entries were dropped by Set, Remove or Invalidate
+	EvictRemoved
+	// EvictPurged entries were dropped by Purge
+	EvictPurged
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictBudget:
+		return "budget"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	case EvictPurged:
+		return "purged"
+	default:
+		return "unknown"
+	}
+}
+
+// Hooks are called on the events of a Group, nil ones are skipped.
+// They run on the goroutine of the event, never with a cache lock held,
+// so they may call back into the group but should return quickly
+type Hooks struct {
+	// OnHit is called for keys served from the main or hot cache,
+	// including keys cached as missing
+	OnHit func(key string, which CacheType)
+	// OnMiss is called for keys found in neither cache
+	OnMiss func(key string)
+	// OnLoad is called once the getter returned for key, values loaded
+	// together by a BatchGetter share the duration of the batch
+	OnLoad func(key string, d time.Duration, err error)
+	// OnPeerFetch is called once peer answered for key, keys fetched
+	// in a batch share the duration of the request
+	OnPeerFetch func(key, peer string, d time.Duration, err error)
+	// OnEvict is called for values leaving a cache, keys cached as
+	// missing are left out and so are the entries of a destroyed group
+	OnEvict func(key string, value ByteView, which CacheType, reason EvictReason)
+}
+
+func (g *Group) hit(key string, which CacheType) {
+	if g.hooks.OnHit != nil {
+		g.hooks.OnHit(key, which)
+	}
+}
+
+func (g *Group) miss(key string) {
+	if g.hooks.OnMiss != nil {
+		g.hooks.OnMiss(key)
+	}
+}
+
+func (g *Group) loaded(key string, d time.Duration, err error) {
+	if g.hooks.OnLoad != nil {
+		g.hooks.OnLoad(key, d, err)
+	}
+}
+
+func (g *Group) fetched(key string, peer PeerGetter, d time.Duration, err error) {
+	if g.hooks.OnPeerFetch != nil {
+		g.hooks.OnPeerFetch(key, peerAddr(peer), d, err)
+	}
+}
+
+// evictHook returns the eviction callback of the cache which, nil without OnEvict
+func (g *Group) evictHook(which CacheType) func(string, ByteView, EvictReason) {
+	if g.hooks.OnEvict == nil {
+		return nil
+	}
+	return func(key string, value ByteView, reason EvictReason) {
+		g.hooks.OnEvict(key, value, which, reason)
+	}
+}
@@ -0,0 +1,93 @@
+package minicache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	var events []string
+	var g *Group
+	hooks := &Hooks{
+		OnHit:  func(key string, which CacheType) { events = append(events, "hit "+key+" "+which.String()) },
+		OnMiss: func(key string) { events = append(events, "miss "+key) },
+		OnLoad: func(key string, d time.Duration, err error) {
+			events = append(events, fmt.Sprintf("load %s %v", key, err))
+		},
+		OnPeerFetch: func(key, peer string, d time.Duration, err error) {
+			events = append(events, fmt.Sprintf("fetch %s %v", key, err != nil))
+		},
+		OnEvict: func(key string, value ByteView, which CacheType, reason EvictReason) {
+			// hooks run without the cache lock and may use the group
+			if g.CacheStats(which); which == HotCache {
+				return // r may be sampled into the hot cache, too small to keep it
+			}
+			events = append(events, fmt.Sprintf("evict %s=%s %s", key, value, reason))
+		},
+	}
+	// the main cache holds a single entry of a one byte key and value
+	size := int64(2 + entryOverhead(LRU))
+	g = NewGroup("hooks", 2*size, GetterFunc(func(key string) ([]byte, error) {
+		if key == "x" {
+			return nil, ErrNotFound
+		}
+		return []byte(key), nil
+	}), &Option{Hooks: hooks})
+	defer DestroyGroup("hooks")
+	g.RegisterPeers(&fakePicker{remote: map[string]bool{"r": true, "q": true}, peers: []*fakePeer{{values: map[string][]byte{"r": []byte("R")}}}})
+
+	expect := func(want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(events, want) {
+			t.Fatalf("expect events %q, got %q", want, events)
+		}
+		events = nil
+	}
+
+	g.Get("a")
+	g.Get("a")
+	expect("miss a", "load a <nil>", "hit a main")
+	g.Get("b")
+	expect("miss b", "load b <nil>", "evict a=a capacity")
+	if _, err := g.Get("x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect x not found, got %v", err)
+	}
+	expect("miss x", "load x not found")
+	g.Get("r")
+	g.Get("q")
+	expect("miss r", "fetch r false", "miss q", "fetch q true", "load q <nil>")
+
+	g.Remove("b")
+	expect("evict b=b removed")
+	g.Get("c")
+	g.Purge()
+	expect("miss c", "load c <nil>", "evict c=c purged")
+}
+
+func TestHooksExpired(t *testing.T) {
+	var reasons []EvictReason
+	g := NewGroup("hooks-expired", 2<<10, ExpireGetterFunc(func(key string) ([]byte, time.Time, error) {
+		return []byte(key), time.Now().Add(10 * time.Millisecond), nil
+	}), &Option{Hooks: &Hooks{
+		OnEvict: func(key string, value ByteView, which CacheType, reason EvictReason) {
+			reasons = append(reasons, reason)
+		},
+	}})
+	defer DestroyGroup("hooks-expired")
+	g.Get("a")
+	g.Get("b")
+	time.Sleep(20 * time.Millisecond)
+	g.Get("a")
+	if n := g.mainCache.removeExpired(); n != 1 {
+		t.Fatalf("expect b left to reclaim, got %d", n)
+	}
+	if !reflect.DeepEqual(reasons, []EvictReason{EvictExpired, EvictExpired}) {
+		t.Fatalf("expect two expired evictions, got %v", reasons)
+	}
+	if s := g.CacheStats(MainCache); s.Evictions != 0 {
+		t.Fatalf("expired entries are not counted as evictions, got %d", s.Evictions)
+	}
+}
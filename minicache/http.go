@@ -1,113 +1,263 @@
 package minicache
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/qingants/pandora/minicache/consistenthash"
 	"github.com/qingants/pandora/minicache/pb"
 )
 
 const (
 	defaultBasePath = "/_minicache/"
 	defaultReplicas = 50
+	// acceptEncodingHeader carries the AcceptEncoding of a GET, the
+	// other requests have it in their body
+	acceptEncodingHeader = "X-Minicache-Accept-Encoding"
 )
 
 type HTTPPool struct {
-	self     string
-	basePath string
-
-	lock        sync.Mutex
-	peers       *consistenthash.Map
-	httpGetters map[string]*httpGetter
+	self        string
+	basePath    string
+	metricsPath string
+	// verifyCert requires peers to present a verified TLS client certificate
+	verifyCert bool
+	secret     []byte
+	*peerSet
 }
 
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+func NewHTTPPool(self string, opts ...*PoolOption) *HTTPPool {
+	p := &HTTPPool{
+		self:        self,
+		basePath:    defaultBasePath,
+		metricsPath: defaultMetricsPath,
+	}
+	var config *tls.Config
+	if len(opts) > 0 && opts[0] != nil {
+		config = opts[0].TLSConfig
+		p.verifyCert = config != nil && config.ClientCAs != nil
+		p.secret = opts[0].Secret
 	}
+	client := newPeerClient(config, peerTimeout(opts))
+	p.peerSet = newPeerSet(self, func(addr string) PeerGetter {
+		return &httpGetter{baseURL: addr + p.basePath, client: client, secret: p.secret}
+	}, p.Log, opts...)
+	return p
 }
 
 func (p *HTTPPool) Log(format string, v ...any) {
 	log.Printf("[server %s] %s", p.self, fmt.Sprintf(format, v...))
 }
 
+// ServeHTTP answers GET with the group's value, POST on the group path with
+// the values of the keys in the pb.BatchRequest body, PUT stores the
+// pb.SetRequest in the body and DELETE drops the key, or every key given none.
+// Writes only touch the local cache, the sender has already routed them to
+// this node. Stats of all groups are served on the metrics path, which
+// needs the same certificate or signature as the peer requests.
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !strings.HasPrefix(r.URL.Path, p.basePath) {
+	if r.URL.Path != p.metricsPath && !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected paht: " + r.URL.Path)
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if !p.authorize(w, r) {
+		return
+	}
+	if r.URL.Path == p.metricsPath {
+		p.serveMetrics(w, r)
+		return
+	}
 
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
+		return
 	}
 
 	groupName := parts[0]
 	key := parts[1]
 
-	group := GetGroup(groupName)
+	group := p.registry.Get(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
-	view, err := group.Get(key)
+
+	switch r.Method {
+	case http.MethodGet:
+		p.serveGet(w, r, group, key)
+	case http.MethodPost:
+		p.serveGetMany(w, r, group)
+	case http.MethodPut:
+		p.serveSet(w, r, group, key)
+	case http.MethodDelete:
+		if key == "" {
+			group.Purge()
+		} else {
+			group.removeCache(key)
+		}
+		p.writeResponse(w, &pb.Response{})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorize checks the client certificate and the signature required
+// of peers. The body is only read once the signature headers are found
+// fresh, it is left for the handlers
+func (p *HTTPPool) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if p.verifyCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return false
+	}
+	if p.secret == nil {
+		return true
+	}
+	reject := func(err error) bool {
+		p.Log("Rejected %s %s %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if _, _, err := signatureHeaders(r); err != nil {
+		return reject(err)
+	}
+	body, err := readBody(w, r)
 	if err != nil {
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err = verifyRequest(r, body, p.secret); err != nil {
+		return reject(err)
+	}
+	return true
+}
+
+// maxRequestBytes bounds the body of the requests served by a pool
+var maxRequestBytes int64 = 64 << 20
+
+// readBody reads the body of r, answering the request if that fails
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+	return body, err
+}
+
+func (p *HTTPPool) serveGet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	group.stats.serverRequests.Add(1)
+	view, err := group.get(r.Context(), key)
+	var accept []string
+	if h := r.Header.Get(acceptEncodingHeader); h != "" {
+		accept = strings.Split(h, ",")
+	}
+	res := &pb.Response{}
+	if err = group.fillResponse(res, view, err, accept); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	p.writeResponse(w, res)
+}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(view.ByteSlice())
+func (p *HTTPPool) serveGetMany(w http.ResponseWriter, r *http.Request, group *Group) {
+	body, err := readBody(w, r)
+	if err != nil {
+		return
+	}
+	req := &pb.BatchRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.stats.serverRequests.Add(int64(len(req.GetKeys())))
+	values, err := group.getMany(r.Context(), req.GetKeys())
+	p.writeMessage(w, batchResponse(req.GetKeys(), values, err, req.GetAcceptEncoding()))
 }
 
-func (p *HTTPPool) Set(peers ...string) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-	p.peers = consistenthash.NewChecksumIEEE(defaultReplicas)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
-	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := readBody(w, r)
+	if err != nil {
+		return
+	}
+	req := &pb.SetRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	group.setLocally(key, req.GetValue(), fromUnixNano(req.GetExpire()))
+	p.writeResponse(w, &pb.Response{})
 }
 
-func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
+func (p *HTTPPool) writeResponse(w http.ResponseWriter, res *pb.Response) {
+	p.writeMessage(w, res)
+}
 
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		p.Log("Pick peer %v", peer)
-		return p.httpGetters[peer], true
+func (p *HTTPPool) writeMessage(w http.ResponseWriter, res proto.Message) {
+	body, err := proto.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	return nil, false
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
 }
 
+var _ PeerPicker = (*HTTPPool)(nil)
+
+var defaultPeerClient = newPeerClient(nil, defaultPeerTimeout)
+
 type httpGetter struct {
 	baseURL string
+	client  *http.Client // defaultPeerClient if nil
+	secret  []byte       // signs requests if set
 }
 
-func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	uri := fmt.Sprintf("%v%v/%v",
+func (h *httpGetter) url(group, key string) string {
+	return fmt.Sprintf("%v%v/%v",
 		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()))
+		url.QueryEscape(group),
+		url.QueryEscape(key))
+}
+
+func (h *httpGetter) do(ctx context.Context, method, uri string, header http.Header, body []byte, out proto.Message) error {
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if h.secret != nil {
+		SignRequest(req, body, h.secret)
+	}
 
-	res, err := http.Get(uri)
+	client := h.client
+	if client == nil {
+		client = defaultPeerClient
+	}
+	res, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned %v", res.Status)
+		return &replyError{fmt.Errorf("server returned %v", res.Status)}
 	}
 
 	bytes, err := io.ReadAll(res.Body)
@@ -122,4 +272,32 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	return nil
 }
 
+func (h *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	var header http.Header
+	if accept := in.GetAcceptEncoding(); len(accept) > 0 {
+		header = http.Header{acceptEncodingHeader: {strings.Join(accept, ",")}}
+	}
+	return h.do(ctx, http.MethodGet, h.url(in.GetGroup(), in.GetKey()), header, nil, out)
+}
+
+func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(ctx, http.MethodPut, h.url(in.GetGroup(), in.GetKey()), nil, body, out)
+}
+
+func (h *httpGetter) Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.Response) error {
+	return h.do(ctx, http.MethodDelete, h.url(in.GetGroup(), in.GetKey()), nil, nil, out)
+}
+
+func (h *httpGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(ctx, http.MethodPost, h.baseURL+url.QueryEscape(in.GetGroup())+"/", nil, body, out)
+}
+
 var _ PeerGetter = (*httpGetter)(nil)
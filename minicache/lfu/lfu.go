@@ -0,0 +1,173 @@
+package lfu
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"github.com/qingants/pandora/minicache/lru"
+)
+
+// Value is use Len to count how many bytes it takes
+type Value = lru.Value
+
+// Cache evicts the least frequently used entry first,
+// ties are broken by evicting the least recently used one
+type Cache struct {
+	maxBytes  int64
+	nbytes    int64
+	tick      uint64
+	queue     queue
+	cache     map[string]*entry
+	OnEvicted func(key string, value Value)
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time // zero means never expire
+	freq   uint64
+	tick   uint64 // last access
+	index  int    // position in queue
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+func New(maxBytes int64, onEvited func(string, Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		cache:     make(map[string]*entry),
+		OnEvicted: onEvited,
+	}
+}
+
+// Get looks up a key's value, an expired entry is removed and reported as a miss
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	if e, ok := c.cache[key]; ok {
+		if e.expired(time.Now()) {
+			c.removeEntry(e)
+			return nil, false
+		}
+		c.touch(e)
+		return e.value, true
+	}
+	return
+}
+
+func (c *Cache) touch(e *entry) {
+	c.tick++
+	e.freq++
+	e.tick = c.tick
+	heap.Fix(&c.queue, e.index)
+}
+
+func (c *Cache) Disuse() {
+	if len(c.queue) > 0 {
+		c.removeEntry(c.queue[0])
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeEntry(e)
+	}
+}
+
+// RemoveExpired drops every expired entry and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, e := range c.cache {
+		if e.expired(now) {
+			c.removeEntry(e)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeEntry(e *entry) {
+	heap.Remove(&c.queue, e.index)
+	delete(c.cache, e.key)
+	c.nbytes -= int64(len(e.key)) + int64(e.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+}
+
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value which is treated as missing once expire has passed,
+// a zero expire means the value never expires
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	if e, ok := c.cache[key]; ok {
+		c.nbytes += int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		c.touch(e)
+	} else {
+		c.tick++
+		e := &entry{key: key, value: value, expire: expire, freq: 1, tick: c.tick}
+		heap.Push(&c.queue, e)
+		c.cache[key] = e
+		c.nbytes += int64(len(key)) + int64(value.Len())
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.Disuse()
+	}
+}
+
+// Walk calls fn for every entry in eviction order
+func (c *Cache) Walk(fn func(key string, value Value, expire time.Time)) {
+	q := append(queue(nil), c.queue...)
+	sort.Slice(q, q.Less)
+	for _, e := range q {
+		fn(e.key, e.value, e.expire)
+	}
+}
+
+func (c *Cache) Len() int {
+	return len(c.queue)
+}
+
+// Bytes returns the bytes taken by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// queue is a min-heap ordered by frequency then by last access
+type queue []*entry
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(i, j int) bool {
+	if q[i].freq != q[j].freq {
+		return q[i].freq < q[j].freq
+	}
+	return q[i].tick < q[j].tick
+}
+
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queue) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *queue) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
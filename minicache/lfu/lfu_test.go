@@ -0,0 +1,62 @@
+package lfu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Str string
+
+func (d Str) Len() int {
+	return len(d)
+}
+
+func TestCache_Get(t *testing.T) {
+	lfu := New(0, nil)
+	lfu.Add("first", Str("abcd"))
+	if v, ok := lfu.Get("first"); !ok || string(v.(Str)) != "abcd" {
+		t.Fatalf("cache hit first=abcd failed")
+	}
+	if _, ok := lfu.Get("second"); ok {
+		t.Fatalf("cache miss second failed")
+	}
+}
+
+func TestCache_Disuse(t *testing.T) {
+	keys := make([]string, 0)
+	lfu := New(int64(len("k1v1k2v2k3v3")), func(key string, value Value) {
+		keys = append(keys, key)
+	})
+	lfu.Add("k1", Str("v1"))
+	lfu.Add("k2", Str("v2"))
+	lfu.Add("k3", Str("v3"))
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+
+	lfu.Add("k4", Str("v4"))
+	lfu.Add("k5", Str("v5"))
+
+	if expect := []string{"k2", "k4"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("evicted keys %s, expect %s", keys, expect)
+	}
+	if _, ok := lfu.Get("k1"); !ok || lfu.Len() != 3 {
+		t.Fatalf("frequently used k1 should stay")
+	}
+}
+
+func TestCache_Expire(t *testing.T) {
+	lfu := New(0, nil)
+	past := time.Now().Add(-time.Second)
+	lfu.AddWithExpire("k1", Str("v1"), past)
+	lfu.Add("k2", Str("v2"))
+	lfu.AddWithExpire("k3", Str("v3"), past)
+
+	if _, ok := lfu.Get("k1"); ok || lfu.Len() != 2 {
+		t.Fatalf("expired k1 should be a miss and removed")
+	}
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 1 || lfu.Bytes() != 4 {
+		t.Fatalf("RemoveExpired() = %d, len %d, bytes %d", n, lfu.Len(), lfu.Bytes())
+	}
+}
@@ -2,6 +2,7 @@ package lru
 
 import (
 	"container/list"
+	"time"
 )
 
 // Value is use Len to count how many bytes it takes
@@ -18,8 +19,13 @@ type Cache struct {
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // zero means never expire
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
 }
 
 func New(maxBytes int64, onEvited func(string, Value)) *Cache {
@@ -31,10 +37,15 @@ func New(maxBytes int64, onEvited func(string, Value)) *Cache {
 	}
 }
 
+// Get looks up a key's value, an expired entry is removed and reported as a miss
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(ele)
+			return nil, false
+		}
+		c.ll.MoveToFront(ele)
 		return kv.value, true
 	}
 	return
@@ -43,24 +54,56 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 func (c *Cache) Disuse() {
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes = int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+// RemoveExpired drops every expired entry and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele)
+			n++
 		}
+		ele = prev
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
 	}
 }
 
 func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value which is treated as missing once expire has passed,
+// a zero expire means the value never expires
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
+		kv.expire = expire
 	} else {
-		el := c.ll.PushFront(&entry{key, value})
+		el := c.ll.PushFront(&entry{key, value, expire})
 		c.cache[key] = el
 		c.nbytes += int64(len(key)) + int64(value.Len())
 	}
@@ -69,6 +112,20 @@ func (c *Cache) Add(key string, value Value) {
 	}
 }
 
+// Walk calls fn for every entry, least recently used first,
+// adding them back in that order restores the recency
+func (c *Cache) Walk(fn func(key string, value Value, expire time.Time)) {
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		kv := ele.Value.(*entry)
+		fn(kv.key, kv.value, kv.expire)
+	}
+}
+
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// Bytes returns the bytes taken by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
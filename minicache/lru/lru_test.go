@@ -6,6 +6,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type Str string
@@ -205,3 +206,38 @@ func TestOnEvited(t *testing.T) {
 		t.Fatalf("call onEvited failed, expect keys %s equals to %s", keys, expect)
 	}
 }
+
+func TestCache_Expire(t *testing.T) {
+	lru := New(0, nil)
+	lru.AddWithExpire("k1", Str("v1"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("k2", Str("v2"), time.Now().Add(time.Hour))
+	lru.Add("k3", Str("v3"))
+
+	if _, ok := lru.Get("k1"); ok || lru.Len() != 2 {
+		t.Fatalf("expired k1 should be a miss and removed")
+	}
+	if v, ok := lru.Get("k2"); !ok || string(v.(Str)) != "v2" {
+		t.Fatalf("cache hit k2=v2 failed")
+	}
+	if lru.nbytes != int64(len("k2v2k3v3")) {
+		t.Fatalf("nbytes %d after removing expired entry", lru.nbytes)
+	}
+}
+
+func TestCache_RemoveExpired(t *testing.T) {
+	keys := make([]string, 0)
+	lru := New(0, func(key string, value Value) {
+		keys = append(keys, key)
+	})
+	past := time.Now().Add(-time.Second)
+	lru.AddWithExpire("k1", Str("v1"), past)
+	lru.Add("k2", Str("v2"))
+	lru.AddWithExpire("k3", Str("v3"), past)
+
+	if n := lru.RemoveExpired(); n != 2 || lru.Len() != 1 {
+		t.Fatalf("RemoveExpired() = %d, len %d", n, lru.Len())
+	}
+	if expect := []string{"k1", "k3"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("evicted keys %s, expect %s", keys, expect)
+	}
+}
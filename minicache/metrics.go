@@ -0,0 +1,122 @@
+package minicache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const defaultMetricsPath = "/metrics"
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s Stats) int64
+}
+
+var groupMetrics = []metric{
+	{"minicache_gets_total", "Get requests, including from peers.", "counter", func(s Stats) int64 { return s.Gets }},
+	{"minicache_hits_total", "Gets served from the main or hot cache.", "counter", func(s Stats) int64 { return s.CacheHits }},
+	{"minicache_hot_hits_total", "Gets served from the hot cache.", "counter", func(s Stats) int64 { return s.HotCacheHits }},
+	{"minicache_negative_hits_total", "Gets answered with a cached not found.", "counter", func(s Stats) int64 { return s.NegativeHits }},
+	{"minicache_stale_hits_total", "Gets served a value past its soft TTL.", "counter", func(s Stats) int64 { return s.StaleHits }},
+	{"minicache_refreshes_total", "Background reloads of stale values.", "counter", func(s Stats) int64 { return s.Refreshes }},
+	{"minicache_misses_total", "Gets that missed both caches and triggered a load.", "counter", func(s Stats) int64 { return s.Loads }},
+	{"minicache_loads_deduped_total", "Loads left after singlefight deduplication.", "counter", func(s Stats) int64 { return s.LoadsDeduped }},
+	{"minicache_peer_loads_total", "Values fetched from peers.", "counter", func(s Stats) int64 { return s.PeerLoads }},
+	{"minicache_peer_errors_total", "Failed fetches from peers.", "counter", func(s Stats) int64 { return s.PeerErrors }},
+	{"minicache_local_loads_total", "Values loaded by the group's Getter.", "counter", func(s Stats) int64 { return s.LocalLoads }},
+	{"minicache_local_load_errors_total", "Failed loads by the group's Getter.", "counter", func(s Stats) int64 { return s.LocalLoadErrs }},
+	{"minicache_server_requests_total", "Gets received from peers.", "counter", func(s Stats) int64 { return s.ServerRequests }},
+}
+
+type peerMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s PeerStats) int64
+}
+
+var peerMetrics = []peerMetric{
+	{"minicache_peer_served_total", "Values served by each peer.", "counter", func(s PeerStats) int64 { return s.Loads }},
+	{"minicache_peer_failures_total", "Failed fetches from each peer.", "counter", func(s PeerStats) int64 { return s.Errors }},
+}
+
+type cacheMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s CacheStats) int64
+}
+
+var cacheMetrics = []cacheMetric{
+	{"minicache_cache_bytes", "Bytes taken by keys, values as stored and the estimated overhead of each entry.", "gauge", func(s CacheStats) int64 { return s.Bytes }},
+	{"minicache_cache_items", "Entries held by the cache.", "gauge", func(s CacheStats) int64 { return s.Items }},
+	{"minicache_cache_gets_total", "Lookups in the cache.", "counter", func(s CacheStats) int64 { return s.Gets }},
+	{"minicache_cache_hits_total", "Lookups that found a value.", "counter", func(s CacheStats) int64 { return s.Hits }},
+	{"minicache_cache_evictions_total", "Entries dropped to stay within the byte budget.", "counter", func(s CacheStats) int64 { return s.Evictions }},
+}
+
+// WriteMetrics writes the stats of groups in the Prometheus text exposition format
+func WriteMetrics(w io.Writer, groups []*Group) {
+	stats := make([]Stats, len(groups))
+	for i, g := range groups {
+		stats[i] = g.Stats()
+	}
+	for _, m := range groupMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i, g := range groups {
+			fmt.Fprintf(w, "%s{group=\"%s\"} %d\n", m.name, escapeLabel(g.name), m.value(stats[i]))
+		}
+	}
+
+	peerStats := make([]map[string]PeerStats, len(groups))
+	for i, g := range groups {
+		peerStats[i] = g.PeerStats()
+	}
+	for _, m := range peerMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i, g := range groups {
+			addrs := make([]string, 0, len(peerStats[i]))
+			for addr := range peerStats[i] {
+				addrs = append(addrs, addr)
+			}
+			sort.Strings(addrs)
+			for _, addr := range addrs {
+				fmt.Fprintf(w, "%s{group=\"%s\",peer=\"%s\"} %d\n",
+					m.name, escapeLabel(g.name), escapeLabel(addr), m.value(peerStats[i][addr]))
+			}
+		}
+	}
+
+	caches := []CacheType{MainCache, HotCache}
+	cacheStats := make([][]CacheStats, len(groups))
+	for i, g := range groups {
+		for _, which := range caches {
+			cacheStats[i] = append(cacheStats[i], g.CacheStats(which))
+		}
+	}
+	for _, m := range cacheMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for i, g := range groups {
+			for j, which := range caches {
+				fmt.Fprintf(w, "%s{group=\"%s\",cache=\"%s\"} %d\n",
+					m.name, escapeLabel(g.name), which, m.value(cacheStats[i][j]))
+			}
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func (p *HTTPPool) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w, p.registry.Groups())
+}
@@ -0,0 +1,96 @@
+package minicache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	var g *Group
+	g = NewGroup("stats", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		// hold the load until every caller has missed the cache and
+		// gone to load the key, they join this one
+		for g.Stats().Loads < 5 {
+			runtime.Gosched()
+		}
+		if key == "unknow" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("stats")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Get("rocky")
+		}()
+	}
+	wg.Wait()
+	g.Get("rocky")
+	g.Get("unknow")
+
+	s := g.Stats()
+	if s.Gets != 7 || s.CacheHits != 1 || s.Loads != 6 || s.LoadsDeduped != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.LocalLoads != 1 || s.LocalLoadErrs != 1 {
+		t.Fatalf("unexpected local load stats %+v", s)
+	}
+}
+
+func TestCacheEvictions(t *testing.T) {
+	c := &cache{cacheBytes: 10}
+	c.add("k1", ByteView{b: []byte("v1")})
+	c.add("k2", ByteView{b: []byte("v2")})
+	c.add("k3", ByteView{b: []byte("v3")})
+	c.remove("k3")
+
+	if s := c.stats(); s.Evictions != 1 || s.Items != 1 || s.Bytes != 4 {
+		t.Fatalf("unexpected cache stats %+v", s)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	g := NewGroup("metrics", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("metrics")
+	g.Get("amy")
+	g.Get("amy")
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	res, err := http.Get(srv.URL + defaultMetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	for _, line := range []string{
+		"# TYPE minicache_gets_total counter",
+		`minicache_gets_total{group="metrics"} 2`,
+		`minicache_hits_total{group="metrics"} 1`,
+		`minicache_cache_items{group="metrics",cache="main"} 1`,
+		`minicache_cache_bytes{group="metrics",cache="hot"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("metrics missing %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("escapeLabel() = %s", got)
+	}
+}
@@ -1,10 +1,15 @@
 package minicache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 
+	"github.com/qingants/pandora/minicache/lru"
 	"github.com/qingants/pandora/minicache/pb"
 	"github.com/qingants/pandora/minicache/singlefight"
 )
@@ -19,85 +24,505 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
-var (
-	m      sync.RWMutex
-	groups = make(map[string]*Group)
+// ExpireGetter is implemented by getters whose values go stale,
+// a zero expire time means the value never expires
+type ExpireGetter interface {
+	GetWithExpire(key string) ([]byte, time.Time, error)
+}
+
+type ExpireGetterFunc func(key string) ([]byte, time.Time, error)
+
+func (f ExpireGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+func (f ExpireGetterFunc) GetWithExpire(key string) ([]byte, time.Time, error) {
+	return f(key)
+}
+
+// ExpireGetterWithContext is implemented by getters taking a context and
+// returning an expire time, it is preferred over the other interfaces
+type ExpireGetterWithContext interface {
+	GetWithContextExpire(ctx context.Context, key string) ([]byte, time.Time, error)
+}
+
+type ExpireGetterWithContextFunc func(ctx context.Context, key string) ([]byte, time.Time, error)
+
+func (f ExpireGetterWithContextFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
+func (f ExpireGetterWithContextFunc) GetWithContextExpire(ctx context.Context, key string) ([]byte, time.Time, error) {
+	return f(ctx, key)
+}
+
+// GetterWithContext is implemented by getters that should stop loading
+// once the caller's deadline passes or it is canceled
+type GetterWithContext interface {
+	GetWithContext(ctx context.Context, key string) ([]byte, error)
+}
+
+type GetterWithContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f GetterWithContextFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f GetterWithContextFunc) GetWithContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// ErrNotFound is returned by getters, possibly wrapped, for keys that do not
+// exist. With Option.NegativeTTL set the group remembers it for that long
+// instead of asking the getter again, peers are told about it too
+var ErrNotFound = errors.New("not found")
+
+// notFoundError is returned by the group for a key reported with ErrNotFound
+type notFoundError struct {
+	key    string
+	expire time.Time // until when the owner remembers it, zero if it does not
+}
+
+func (e *notFoundError) Error() string {
+	return e.key + ": " + ErrNotFound.Error()
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// hotCacheRatio is the share of cacheBytes given to the hot cache,
+// 1 in hotCacheSample values fetched from peers are kept in it
+const (
+	hotCacheRatio  = 8
+	hotCacheSample = 10
 )
 
 type Group struct {
-	name      string
-	getter    Getter
-	mainCache cache
+	name   string
+	getter Getter
+	// mainCache holds the keys this process owns, hotCache holds copies
+	// of popular keys owned by peers to save the network round trip
+	mainCache *shardedCache
+	hotCache  cache
 	peers     PeerPicker
 	loader    *singlefight.Group
+	stats     groupStats
+	// negativeTTL is how long ErrNotFound is cached, zero disables it
+	negativeTTL time.Duration
+	softTTL     time.Duration
+	hardTTL     time.Duration
+	refreshing  sync.Map // keys being refreshed in the background
+	mem         *memAccount
+	// decoded holds the values of a TypedGroup, evicted first by the Budget
+	// once they take more than their share of the group
+	decoded *decodedCache
+	// compressor compresses main cache values of compressThreshold bytes or more
+	compressor        Compressor
+	compressThreshold int
+	hooks             Hooks
+	// stopSnapshot stops the periodic snapshot started by Option.SnapshotInterval
+	stopSnapshot func()
+	// ctx is canceled when the group is destroyed, stopping background reloads
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Option configures a Group, zero fields keep the DefaultOption value
+type Option struct {
+	Policy PolicyType // eviction policy of the main and hot caches
+	Shards int        // number of main cache segments, each with its own lock
+	// SnapshotPath is a snapshot loaded when the group is created,
+	// it is saved every SnapshotInterval if that is set
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+	// NegativeTTL turns on caching of keys the getter reports with ErrNotFound
+	NegativeTTL time.Duration
+	// A loaded value older than SoftTTL is still returned but reloaded in
+	// the background, one older than HardTTL is dropped and callers wait for
+	// the reload. Zero turns either off, the getter's expiry still applies
+	SoftTTL time.Duration
+	HardTTL time.Duration
+	// Budget is a memory limit shared with other groups, cacheBytes still
+	// caps the group and may be 0 to leave it to the Budget alone
+	Budget *Budget
+	// Weight is the share of the Budget the group is entitled to relative
+	// to the other groups, it defaults to 1
+	Weight int
+	// Compressor compresses values of CompressThreshold bytes or more in
+	// the main cache, the threshold defaults to 1KB
+	Compressor        Compressor
+	CompressThreshold int
+	// Hooks are called on hits, misses, loads, peer fetches and evictions
+	Hooks *Hooks
+
+	// decodedBytes is set by NewTypedGroup to keep its decoded values
+	decodedBytes int64
+}
+
+var DefaultOption = &Option{
+	Policy: LRU,
+	Shards: 1,
 }
 
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	if getter == nil {
-		panic("nil getter")
+func parseOptions(opts ...*Option) *Option {
+	if len(opts) == 0 || opts[0] == nil {
+		return DefaultOption
+	}
+	opt := *opts[0]
+	if opt.Shards <= 0 {
+		opt.Shards = DefaultOption.Shards
+	}
+	if opt.Weight <= 0 {
+		opt.Weight = 1
 	}
-	m.Lock()
-	defer m.Unlock()
+	if opt.CompressThreshold <= 0 {
+		opt.CompressThreshold = defaultCompressThreshold
+	}
+	return &opt
+}
 
-	groups[name] = &Group{
+func newGroup(name string, cacheBytes int64, getter Getter, opt *Option) *Group {
+	var mem *memAccount
+	if opt.Budget != nil {
+		mem = &memAccount{budget: opt.Budget, weight: int64(opt.Weight)}
+	}
+	hotBytes := cacheBytes / hotCacheRatio
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &Group{
 		name:      name,
 		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singlefight.Group{},
-	}
+		mainCache: newShardedCache(opt.Shards, cacheBytes-hotBytes, opt.Policy, mem),
+		hotCache: cache{
+			cacheBytes: hotBytes,
+			policyType: opt.Policy,
+			overhead:   entryOverhead(opt.Policy),
+			mem:        mem,
+		},
+		loader:      &singlefight.Group{},
+		mem:         mem,
+		negativeTTL: opt.NegativeTTL,
+		softTTL:     opt.SoftTTL,
+		hardTTL:     opt.HardTTL,
+
+		compressor:        opt.Compressor,
+		compressThreshold: opt.CompressThreshold,
 
-	return groups[name]
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if opt.Hooks != nil {
+		g.hooks = *opt.Hooks
+		for _, c := range g.mainCache.shards {
+			c.onEvict = g.evictHook(MainCache)
+		}
+		g.hotCache.onEvict = g.evictHook(HotCache)
+	}
+	if opt.Compressor != nil {
+		RegisterCompressor(opt.Compressor)
+	}
+	if opt.decodedBytes > 0 {
+		g.decoded = &decodedCache{lru: lru.New(opt.decodedBytes, nil), mem: mem}
+	}
+	if mem != nil {
+		opt.Budget.attach(g)
+	}
+	g.warmUp(opt)
+	return g
 }
 
-func GetGroup(name string) *Group {
-	m.RLock()
-	defer m.RUnlock()
-	return groups[name]
+// close stops the background work of the group and releases its memory
+func (g *Group) close() {
+	g.cancel()
+	if g.stopSnapshot != nil {
+		g.stopSnapshot()
+	}
+	g.mainCache.close()
+	g.hotCache.close()
+	if g.decoded != nil {
+		g.decoded.clear()
+	}
+	if g.mem != nil {
+		g.mem.budget.detach(g)
+	}
 }
 
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get but gives up once ctx is done, ctx is passed on
+// to the peer or the getter loading the value
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	v, err := g.get(ctx, key)
+	if err != nil {
+		return v, err
+	}
+	return g.decompressed(key, v)
+}
+
+// decompressed returns v uncompressed for the caller of the group,
+// a value that can not be decompressed is dropped so it is loaded again
+func (g *Group) decompressed(key string, v ByteView) (ByteView, error) {
+	dv, err := v.decompressed()
+	if err != nil {
+		log.Printf("[MiniCache] Dropping corrupt value of %s %v", key, err)
+		g.removeCache(key)
+	}
+	return dv, err
+}
+
+// get is GetContext keeping compressed values compressed, as they are
+// sent to peers
+func (g *Group) get(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
 
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[MiniCache] hit")
+	g.stats.gets.Add(1)
+	if v, ok := g.lookupCache(key); ok {
+		g.stats.cacheHits.Add(1)
+		if v.notFound {
+			g.stats.negativeHits.Add(1)
+			return ByteView{}, &notFoundError{key: key, expire: v.e}
+		}
+		g.revalidate(key, v)
 		return v, nil
 	}
 
-	return g.load(key)
+	return g.load(ctx, key)
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
-	viewi, err := g.loader.Do(key, func() (any, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.GetFromPeer(peer, key); err == nil {
-					return value, nil
+// withTTL applies the soft and hard TTL to a value just loaded
+func (g *Group) withTTL(v ByteView) ByteView {
+	now := time.Now()
+	if g.hardTTL > 0 {
+		if hard := now.Add(g.hardTTL); v.e.IsZero() || hard.Before(v.e) {
+			v.e = hard
+		}
+	}
+	if g.softTTL > 0 {
+		v.r = now.Add(g.softTTL)
+	}
+	return v
+}
+
+// revalidate starts a background reload of key if v went stale,
+// at most one runs at a time for each key
+func (g *Group) revalidate(key string, v ByteView) {
+	if v.r.IsZero() || time.Now().Before(v.r) {
+		return
+	}
+	g.stats.staleHits.Add(1)
+	if g.ctx.Err() != nil {
+		return
+	}
+	if _, running := g.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	g.stats.refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		// the caller already has its value, the reload must not be bound to its context
+		if _, err := g.load(g.ctx, key); err != nil && g.ctx.Err() == nil {
+			log.Printf("[MiniCache] Failed to refresh %s %v", key, err)
+		}
+	}()
+}
+
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.mainCache.get(key); ok {
+		g.hit(key, MainCache)
+		return v, ok
+	}
+	if v, ok := g.hotCache.get(key); ok {
+		g.stats.hotCacheHits.Add(1)
+		g.hit(key, HotCache)
+		return v, ok
+	}
+	g.miss(key)
+	return ByteView{}, false
+}
+
+// load fetches key once for all the callers asking for it at the same
+// time. The load is not bound to the context of the first of them, each
+// caller stops waiting at its own deadline and the load is canceled once
+// none is left
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
+	g.stats.loads.Add(1)
+	viewi, err, _ := g.loader.DoDetached(ctx, key, func(ctx context.Context) (any, error) {
+		g.stats.loadsDeduped.Add(1)
+		peers, isOwner := g.owners(key)
+		if isOwner {
+			return g.getLocally(ctx, key)
+		}
+		for _, peer := range peers {
+			addr := peerAddr(peer)
+			start := time.Now()
+			value, err := g.getFromPeer(ctx, peer, key)
+			g.fetched(key, peer, time.Since(start), err)
+			if errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+			if err == nil {
+				g.stats.peerLoads.Add(1)
+				g.stats.peer(addr).loads.Add(1)
+				log.Printf("[MiniCache] %s served by peer %s", key, addr)
+				if rand.Intn(hotCacheSample) == 0 {
+					g.hotCache.add(key, value)
 				}
-				log.Printf("[MiniCache] Failed to get from peer %v", err)
+				return value, nil
+			}
+			g.stats.peerErrors.Add(1)
+			g.stats.peer(addr).errors.Add(1)
+			log.Printf("[MiniCache] Failed to get from peer %s %v", addr, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
 		}
-		return g.GetLocally(key)
+		// no owner could serve key, load it without keeping a copy
+		// in the main cache of a node that does not own it
+		return g.loadLocally(ctx, key)
 	})
-	if err == nil {
-		return viewi.(ByteView), nil
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(ByteView), nil
+}
+
+// owners returns the peers owning key and whether this process owns it too
+func (g *Group) owners(key string) ([]PeerGetter, bool) {
+	if g.peers == nil {
+		return nil, true
+	}
+	if rp, ok := g.peers.(ReplicaPicker); ok {
+		return rp.PickPeers(key)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []PeerGetter{peer}, false
 	}
-	return
+	return nil, true
 }
 
 func (g *Group) GetLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+	return g.getLocally(context.Background(), key)
+}
+
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	value, err := g.loadLocally(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return ByteView{}, g.notFound(key, true)
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
 	g.setCache(key, value)
 	return value, nil
 }
 
+// notFound returns the error for a missing key, caching it if populate
+// is set and negative caching is on
+func (g *Group) notFound(key string, populate bool) error {
+	if g.negativeTTL <= 0 {
+		return &notFoundError{key: key}
+	}
+	expire := time.Now().Add(g.negativeTTL)
+	if populate {
+		g.setCache(key, ByteView{e: expire, notFound: true})
+	}
+	return &notFoundError{key: key, expire: expire}
+}
+
+// fillResponse answers the Get of a peer, a missing key is a reply
+// rather than an error so the peer stops looking for it
+func (g *Group) fillResponse(out *pb.Response, view ByteView, err error, accept []string) error {
+	var nf *notFoundError
+	if errors.As(err, &nf) {
+		out.NotFound = true
+		out.Expire = toUnixNano(nf.expire)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	out.Value, out.Encoding, err = view.encode(accept)
+	out.Expire = toUnixNano(view.Expire())
+	return err
+}
+
+// loadLocally calls the getter without caching the value
+func (g *Group) loadLocally(ctx context.Context, key string) (ByteView, error) {
+	var (
+		bytes  []byte
+		expire time.Time
+		err    error
+	)
+	start := time.Now()
+	switch getter := g.getter.(type) {
+	case ExpireGetterWithContext:
+		bytes, expire, err = getter.GetWithContextExpire(ctx, key)
+	case GetterWithContext:
+		bytes, err = getter.GetWithContext(ctx, key)
+	case ExpireGetter:
+		bytes, expire, err = getter.GetWithExpire(key)
+	default:
+		bytes, err = getter.Get(key)
+	}
+	g.loaded(key, time.Since(start), err)
+	if err != nil {
+		g.stats.localLoadErrs.Add(1)
+		return ByteView{}, err
+	}
+	g.stats.localLoads.Add(1)
+	return g.withTTL(ByteView{b: cloneBytes(bytes), e: expire}), nil
+}
+
+// setLocally stores a value pushed with Set, the soft and hard TTL
+// apply as to a loaded value
+func (g *Group) setLocally(key string, value []byte, expire time.Time) {
+	g.setCache(key, g.withTTL(ByteView{b: value, e: expire}))
+}
+
 func (g *Group) setCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+	g.mainCache.add(key, g.compress(value))
+}
+
+func (g *Group) removeCache(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Purge drops every entry of the group cached by this process,
+// peers keep theirs
+func (g *Group) Purge() {
+	g.mainCache.clear()
+	g.hotCache.clear()
+}
+
+func (g *Group) Stats() Stats {
+	return g.stats.snapshot()
+}
+
+// PeerStats returns the fetches of the group by peer address
+func (g *Group) PeerStats() map[string]PeerStats {
+	return g.stats.peerSnapshot()
+}
+
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
 }
 
 func (g *Group) RegisterPeers(peers PeerPicker) {
@@ -108,15 +533,130 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 }
 
 func (g *Group) GetFromPeer(peer PeerGetter, key string) (ByteView, error) {
+	v, err := g.getFromPeer(context.Background(), peer, key)
+	if err != nil {
+		return v, err
+	}
+	return v.decompressed()
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
-		Group: g.name,
-		Key:   key,
+		Group:          g.name,
+		Key:            key,
+		AcceptEncoding: g.acceptEncoding(),
 	}
 
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	err := peer.Get(ctx, req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.GetValue()}, nil
+	if res.GetNotFound() {
+		return ByteView{}, g.peerNotFound(key, fromUnixNano(res.GetExpire()))
+	}
+	return decodeView(res.GetValue(), res.GetEncoding(), fromUnixNano(res.GetExpire()))
+}
+
+// peerNotFound keeps a key the owner reported missing in the hot cache
+// for as long as the owner remembers it
+func (g *Group) peerNotFound(key string, expire time.Time) error {
+	if !expire.IsZero() {
+		g.hotCache.add(key, ByteView{e: expire, notFound: true})
+	}
+	return &notFoundError{key: key, expire: expire}
+}
+
+// Set stores value on the peers owning key and drops the local copy
+// unless this process is one of the owners
+func (g *Group) Set(key string, value []byte, expire time.Time) error {
+	return g.SetContext(context.Background(), key, value, expire)
+}
+
+func (g *Group) SetContext(ctx context.Context, key string, value []byte, expire time.Time) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	peers, isOwner := g.owners(key)
+	req := &pb.SetRequest{
+		Group:  g.name,
+		Key:    key,
+		Value:  value,
+		Expire: toUnixNano(expire),
+	}
+	var first error
+	for _, peer := range peers {
+		if err := peer.Set(ctx, req, &pb.Response{}); err != nil && first == nil {
+			first = err
+		}
+	}
+	if isOwner {
+		g.setLocally(key, cloneBytes(value), expire)
+	} else {
+		g.removeCache(key)
+	}
+	return first
+}
+
+// Remove deletes key from the peers owning it and drops the local copy
+func (g *Group) Remove(key string) error {
+	return g.RemoveContext(context.Background(), key)
+}
+
+func (g *Group) RemoveContext(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeCache(key)
+	peers, _ := g.owners(key)
+	var first error
+	req := &pb.RemoveRequest{Group: g.name, Key: key}
+	for _, peer := range peers {
+		if err := peer.Remove(ctx, req, &pb.Response{}); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Invalidate deletes key from every peer, not only its owner, so copies
+// left behind by a membership change or a failed peer fetch are dropped too
+func (g *Group) Invalidate(key string) error {
+	return g.InvalidateContext(context.Background(), key)
+}
+
+func (g *Group) InvalidateContext(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeCache(key)
+	if g.peers == nil {
+		return nil
+	}
+
+	var first error
+	req := &pb.RemoveRequest{Group: g.name, Key: key}
+	for _, peer := range g.peers.GetAll() {
+		if err := peer.Remove(ctx, req, &pb.Response{}); err != nil {
+			log.Printf("[MiniCache] Failed to invalidate on peer %v", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
 }
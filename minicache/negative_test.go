@@ -0,0 +1,108 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+func TestNegativeCache(t *testing.T) {
+	calls := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		calls++
+		return nil, fmt.Errorf("no row for %s: %w", key, ErrNotFound)
+	})
+
+	g := NewGroup("negative-off", 2<<10, getter)
+	defer DestroyGroup("negative-off")
+	g.Get("missing")
+	g.Get("missing")
+	if calls != 2 {
+		t.Fatalf("without NegativeTTL every get should reach the getter, got %d calls", calls)
+	}
+
+	calls = 0
+	g = NewGroup("negative", 2<<10, getter, &Option{NegativeTTL: 50 * time.Millisecond})
+	defer DestroyGroup("negative")
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, got %v", err)
+		}
+	}
+	if calls != 1 || g.Stats().NegativeHits != 2 {
+		t.Fatalf("expect one getter call and 2 negative hits, got %d %+v", calls, g.Stats())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	g.Get("missing")
+	if calls != 2 {
+		t.Fatalf("not found should be forgotten after the TTL")
+	}
+
+	if err := g.Set("missing", []byte("now here"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := g.Get("missing"); err != nil || view.String() != "now here" {
+		t.Fatalf("set should replace a cached not found, got %s %v", view, err)
+	}
+}
+
+func TestNegativeCacheOverHTTP(t *testing.T) {
+	g := NewGroup("negative-http", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), &Option{NegativeTTL: time.Minute})
+	defer DestroyGroup("negative-http")
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: g.name, Key: "nope"}, res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.GetNotFound() || res.GetExpire() == 0 {
+		t.Fatalf("expect a not found reply with an expiry, got %+v", res)
+	}
+
+	// the requesting side remembers it in the hot cache
+	g.removeCache("nope")
+	if _, err := g.GetFromPeer(peer, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound from the peer, got %v", err)
+	}
+	if v, ok := g.hotCache.get("nope"); !ok || !v.notFound {
+		t.Fatalf("peer not found should be kept in the hot cache")
+	}
+
+	batch := &pb.BatchResponse{}
+	if err := peer.GetMany(context.Background(), &pb.BatchRequest{Group: g.name, Keys: []string{"nope"}}, batch); err != nil {
+		t.Fatal(err)
+	}
+	if entries := batch.GetEntries(); len(entries) != 1 || !entries[0].GetNotFound() {
+		t.Fatalf("expect a not found entry, got %v", entries)
+	}
+}
+
+func TestNegativeCacheBatch(t *testing.T) {
+	calls := 0
+	g := NewGroup("negative-batch", 2<<10, BatchGetterFunc(func(keys []string) (map[string][]byte, error) {
+		calls++
+		return map[string][]byte{"here": []byte("v")}, nil
+	}), &Option{NegativeTTL: time.Minute})
+	defer DestroyGroup("negative-batch")
+
+	for i := 0; i < 2; i++ {
+		values, err := g.GetMany([]string{"here", "gone"})
+		var errs BatchError
+		if !errors.As(err, &errs) || !errors.Is(errs["gone"], ErrNotFound) || values["here"].String() != "v" {
+			t.Fatalf("unexpected result %v %v", values, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expect the missing key to be cached, got %d calls", calls)
+	}
+}
@@ -1,11 +1,27 @@
 package minicache
 
-import "github.com/qingants/pandora/minicache/pb"
+import (
+	"context"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
 
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// GetAll returns every peer except self
+	GetAll() []PeerGetter
 }
 
 type PeerGetter interface {
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error
+	Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.Response) error
+	GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// ReplicaPicker is implemented by PeerPickers placing each key on several
+// nodes. PickPeers returns the owners of key other than self in the order
+// they should be tried, isOwner tells if self is one of them
+type ReplicaPicker interface {
+	PickPeers(key string) (peers []PeerGetter, isOwner bool)
 }
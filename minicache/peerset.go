@@ -0,0 +1,253 @@
+package minicache
+
+import (
+	"crypto/tls"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/qingants/pandora/minicache/consistenthash"
+)
+
+// PoolOption configures the key placement of an HTTPPool or RPCPool
+type PoolOption struct {
+	// Picker places keys on peers, it must be empty and defaults to
+	// a consistenthash.Map ring. A Map with SetLoadFactor bounds the
+	// requests in flight to each peer, one added to by AddWeightedPeer
+	// gives peers a share of the keys in proportion to their weight
+	Picker consistenthash.Picker
+	// Owners is the number of distinct peers each key is placed on,
+	// they are tried in order when fetching the key. Defaults to 1
+	Owners int
+	// Registry holds the groups served to peers, DefaultRegistry if nil
+	Registry *Registry
+	// TLSConfig is used by an HTTPPool to dial its https peers, see
+	// NewPeerTLSConfig. With ClientCAs set the pool only answers requests
+	// over TLS with a verified client certificate
+	TLSConfig *tls.Config
+	// Secret is a key shared by the peers of an HTTPPool, requests
+	// are signed with it and answered only if the signature is valid
+	Secret []byte
+	// Timeout bounds every request to a peer, it defaults to
+	// defaultPeerTimeout and a negative value turns it off
+	Timeout time.Duration
+}
+
+// defaultPeerTimeout keeps callers from waiting forever on a dead peer
+const defaultPeerTimeout = 10 * time.Second
+
+// peerTimeout returns the Timeout of opts, 0 if turned off
+func peerTimeout(opts []*PoolOption) time.Duration {
+	if len(opts) == 0 || opts[0] == nil || opts[0].Timeout == 0 {
+		return defaultPeerTimeout
+	}
+	if opts[0].Timeout < 0 {
+		return 0
+	}
+	return opts[0].Timeout
+}
+
+// peerSet is the membership shared by HTTPPool and RPCPool
+type peerSet struct {
+	self     string
+	owners   int
+	registry *Registry
+	newPeer  func(addr string) PeerGetter
+	logf     func(format string, v ...any)
+
+	lock   sync.Mutex
+	picker consistenthash.Picker
+	peers  map[string]*peer
+}
+
+func newPeerSet(self string, newPeer func(addr string) PeerGetter, logf func(format string, v ...any), opts ...*PoolOption) *peerSet {
+	s := &peerSet{
+		self:     self,
+		owners:   1,
+		registry: DefaultRegistry,
+		newPeer:  newPeer,
+		logf:     logf,
+		picker:   consistenthash.NewChecksumIEEE(defaultReplicas),
+		peers:    make(map[string]*peer),
+	}
+	if len(opts) > 0 && opts[0] != nil {
+		if opts[0].Picker != nil {
+			s.picker = opts[0].Picker
+		}
+		if opts[0].Owners > 1 {
+			s.owners = opts[0].Owners
+		}
+		if opts[0].Registry != nil {
+			s.registry = opts[0].Registry
+		}
+	}
+	return s
+}
+
+// Set makes peers the whole membership, peers already known keep their
+// place and their connections so only the keys of added or removed peers move
+func (s *peerSet) Set(peers ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	added, removed := diffPeers(s.picker.Nodes(), peers)
+	s.removePeers(removed...)
+	s.addPeers(added...)
+}
+
+func (s *peerSet) AddPeers(peers ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.addPeers(peers...)
+}
+
+// weightedPicker is implemented by pickers giving nodes a share of the
+// keys in proportion to their weight, such as consistenthash.Map
+type weightedPicker interface {
+	AddWeighted(node string, weight int)
+}
+
+// AddWeightedPeer adds peer with weight times the keys of a peer added
+// by AddPeers, the weight is ignored by pickers not supporting it
+func (s *peerSet) AddWeightedPeer(peer string, weight int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if wp, ok := s.picker.(weightedPicker); ok {
+		wp.AddWeighted(peer, weight)
+	} else {
+		s.picker.Add(peer)
+	}
+	s.newPeers(peer)
+}
+
+// RemovePeers takes peers out and closes their connections
+func (s *peerSet) RemovePeers(peers ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.removePeers(peers...)
+}
+
+// loadTracker is implemented by pickers placing keys by the load of the
+// peers, it is told about every request to a peer
+type loadTracker interface {
+	Inc(node string)
+	Done(node string)
+}
+
+func (s *peerSet) addPeers(peers ...string) {
+	s.picker.Add(peers...)
+	s.newPeers(peers...)
+}
+
+// newPeers connects to the peers just put on the picker
+func (s *peerSet) newPeers(peers ...string) {
+	load, _ := s.picker.(loadTracker)
+	for _, addr := range peers {
+		if _, ok := s.peers[addr]; !ok {
+			p := newPeer(addr, s.newPeer(addr))
+			p.load = load
+			s.peers[addr] = p
+		}
+	}
+}
+
+func (s *peerSet) removePeers(peers ...string) {
+	s.picker.Remove(peers...)
+	for _, addr := range peers {
+		if p, ok := s.peers[addr]; ok {
+			closePeer(p)
+			delete(s.peers, addr)
+		}
+	}
+}
+
+func closePeer(p *peer) {
+	if c, ok := p.PeerGetter.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// Peers returns the current membership, self included
+func (s *peerSet) Peers() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.picker.Nodes()
+}
+
+// Watch applies every peer list w reports with Set until stop is called
+func (s *peerSet) Watch(w PeerWatcher) (stop func()) {
+	return watchPeers(w, s.Set)
+}
+
+func (s *peerSet) PickPeer(key string) (PeerGetter, bool) {
+	peers, isOwner := s.PickPeers(key)
+	if isOwner || len(peers) == 0 {
+		return nil, false
+	}
+	return peers[0], true
+}
+
+// PickPeers returns the owners of key other than self, healthy ones first
+func (s *peerSet) PickPeers(key string) ([]PeerGetter, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		healthy, unhealthy []PeerGetter
+		isOwner            bool
+	)
+	for _, addr := range s.picker.GetN(key, s.owners) {
+		if addr == s.self {
+			isOwner = true
+			continue
+		}
+		p, ok := s.peers[addr]
+		if !ok {
+			// put on the picker by someone other than the pool
+			continue
+		}
+		if p.healthy() {
+			healthy = append(healthy, p)
+		} else {
+			unhealthy = append(unhealthy, p)
+		}
+	}
+	peers := append(healthy, unhealthy...)
+	if !isOwner && len(peers) > 0 {
+		s.logf("Pick peer %v", peerAddr(peers[0]))
+	}
+	return peers, isOwner
+}
+
+// Owners returns the addresses of the nodes owning key, self included,
+// the first is the one asked first
+func (s *peerSet) Owners(key string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.picker.GetN(key, s.owners)
+}
+
+func (s *peerSet) GetAll() []PeerGetter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	getters := make([]PeerGetter, 0, len(s.peers))
+	for addr, p := range s.peers {
+		if addr != s.self {
+			getters = append(getters, p)
+		}
+	}
+	return getters
+}
+
+// Close closes the connections to all peers
+func (s *peerSet) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, p := range s.peers {
+		closePeer(p)
+	}
+	return nil
+}
+
+var _ ReplicaPicker = (*peerSet)(nil)
@@ -0,0 +1,119 @@
+package minicache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// syntheticTraces uses fixed seeds so every policy replays the same
+// sequence of keys. None is a recorded cache access log: nethttp-idents is
+// the identifiers of the non-test files of Go's net/http in the order they
+// appear, a skewed sequence with some locality
+func syntheticTraces(tb testing.TB) map[string][]string {
+	return map[string][]string{
+		"zipf":           zipfTrace(1, 100000, 10000),
+		"zipf+scan":      scanTrace(zipfTrace(2, 100000, 10000), 5000, 20000),
+		"loop":           loopTrace(100000, 1500),
+		"nethttp-idents": readTrace(tb, "testdata/nethttp-idents.gz"),
+	}
+}
+
+// readTrace reads a gzipped file of one key per line
+func readTrace(tb testing.TB, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var trace []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		trace = append(trace, s.Text())
+	}
+	if err = s.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return trace
+}
+
+func zipfTrace(seed int64, n int, keys uint64) []string {
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.1, 1, keys-1)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%d", z.Uint64())
+	}
+	return trace
+}
+
+// scanTrace interleaves one-off scans of scanLen unseen keys every period accesses
+func scanTrace(base []string, scanLen, period int) []string {
+	trace := make([]string, 0, len(base)+len(base)/period*scanLen)
+	for i, key := range base {
+		if i > 0 && i%period == 0 {
+			for j := 0; j < scanLen; j++ {
+				trace = append(trace, fmt.Sprintf("scan%d-%d", i, j))
+			}
+		}
+		trace = append(trace, key)
+	}
+	return trace
+}
+
+func loopTrace(n, keys int) []string {
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%d", i%keys)
+	}
+	return trace
+}
+
+func hitRatio(t PolicyType, cacheBytes int64, trace []string) float64 {
+	c := &cache{cacheBytes: cacheBytes, policyType: t}
+	value := ByteView{b: make([]byte, 64)}
+	for _, key := range trace {
+		if _, ok := c.get(key); !ok {
+			c.add(key, value)
+		}
+	}
+	s := c.stats()
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+func TestPolicies(t *testing.T) {
+	for _, p := range []PolicyType{LRU, LFU, ARC, TinyLFU} {
+		c := &cache{cacheBytes: 1000, policyType: p}
+		for i := 0; i < 100; i++ {
+			c.add(fmt.Sprintf("key%d", i), ByteView{b: []byte("0123456789")})
+		}
+		if s := c.stats(); s.Bytes > 1000 || s.Items == 0 {
+			t.Fatalf("policy %d holds %d items in %d bytes", p, s.Items, s.Bytes)
+		}
+		c.add("k", ByteView{b: []byte("v")})
+		if v, ok := c.get("k"); !ok || v.String() != "v" {
+			t.Fatalf("policy %d lost the latest key", p)
+		}
+	}
+}
+
+func BenchmarkPolicyHitRatioSynthetic(b *testing.B) {
+	policies := map[string]PolicyType{"LRU": LRU, "LFU": LFU, "ARC": ARC, "TinyLFU": TinyLFU}
+	for traceName, trace := range syntheticTraces(b) {
+		for name, p := range policies {
+			b.Run(traceName+"/"+name, func(b *testing.B) {
+				var ratio float64
+				for i := 0; i < b.N; i++ {
+					ratio = hitRatio(p, 1000*(64+8), trace)
+				}
+				b.ReportMetric(ratio*100, "hit%")
+			})
+		}
+	}
+}
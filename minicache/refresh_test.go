@@ -0,0 +1,91 @@
+package minicache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int64
+	refreshing := make(chan struct{})
+	release := make(chan struct{})
+	g := NewGroup("stale", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if version.Load() == 1 {
+			close(refreshing)
+			<-release
+		}
+		return []byte(fmt.Sprintf("v%d", version.Add(1))), nil
+	}), &Option{SoftTTL: 20 * time.Millisecond, HardTTL: time.Hour})
+	defer DestroyGroup("stale")
+
+	if view, err := g.Get("k"); err != nil || view.String() != "v1" {
+		t.Fatalf("first get = %s %v", view, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// the stale get starts the refresh, which stays blocked in the getter
+	// while the stale value keeps being served
+	if view, err := g.Get("k"); err != nil || view.String() != "v1" {
+		t.Fatalf("stale get = %s %v", view, err)
+	}
+	<-refreshing
+	for i := 0; i < 4; i++ {
+		if view, err := g.Get("k"); err != nil || view.String() != "v1" {
+			t.Fatalf("stale get = %s %v", view, err)
+		}
+	}
+	if s := g.Stats(); s.StaleHits != 5 || s.Refreshes != 1 {
+		t.Fatalf("expect 5 stale hits and a single refresh, got %+v", s)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		view, _ := g.Get("k")
+		if view.String() == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("value was not refreshed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHardTTL(t *testing.T) {
+	var calls atomic.Int64
+	g := NewGroup("hard-ttl", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(fmt.Sprintf("v%d", calls.Add(1))), nil
+	}), &Option{SoftTTL: 10 * time.Millisecond, HardTTL: 20 * time.Millisecond})
+	defer DestroyGroup("hard-ttl")
+
+	g.Get("k")
+	time.Sleep(30 * time.Millisecond)
+	if view, err := g.Get("k"); err != nil || view.String() != "v2" {
+		t.Fatalf("expect a blocking reload after the hard TTL, got %s %v", view, err)
+	}
+	if s := g.Stats(); s.StaleHits != 0 || calls.Load() != 2 {
+		t.Fatalf("unexpected stats %+v after %d loads", s, calls.Load())
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	g := NewGroup("set-ttl", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}), &Option{SoftTTL: time.Hour, HardTTL: 20 * time.Millisecond})
+	defer DestroyGroup("set-ttl")
+
+	if err := g.Set("k", []byte("pushed"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	view, err := g.Get("k")
+	if err != nil || view.String() != "pushed" || view.Expire().IsZero() {
+		t.Fatalf("expect the pushed value bound by the hard TTL, got %s %v %v", view, view.Expire(), err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if view, err := g.Get("k"); err != nil || view.String() != "loaded" {
+		t.Fatalf("expect a reload once the hard TTL passed, got %s %v", view, err)
+	}
+}
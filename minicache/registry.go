@@ -0,0 +1,137 @@
+package minicache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrGroupExists = errors.New("minicache: group already exists")
+
+// Registry holds groups by name. Pools resolve the groups peers ask for
+// in the registry of their PoolOption, DefaultRegistry otherwise, which
+// is the one of NewGroup and GetGroup
+type Registry struct {
+	lock   sync.RWMutex
+	groups map[string]*Group
+}
+
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// GroupInfo describes a group listed by Registry.List
+type GroupInfo struct {
+	Name      string
+	Stats     Stats
+	MainCache CacheStats
+	HotCache  CacheStats
+}
+
+// NewGroup creates a group, it fails with ErrGroupExists if name is taken
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter, opts ...*Option) (*Group, error) {
+	if getter == nil {
+		panic("nil getter")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.groups[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	g := newGroup(name, cacheBytes, getter, parseOptions(opts...))
+	r.groups[name] = g
+	return g, nil
+}
+
+// GetOrCreate returns the group named name, creating it if there is
+// none. created tells if it did, otherwise the arguments are ignored
+func (r *Registry) GetOrCreate(name string, cacheBytes int64, getter Getter, opts ...*Option) (g *Group, created bool) {
+	if g := r.Get(name); g != nil {
+		return g, false
+	}
+	g, err := r.NewGroup(name, cacheBytes, getter, opts...)
+	if err != nil {
+		// created by someone else in the meantime
+		return r.Get(name), false
+	}
+	return g, true
+}
+
+func (r *Registry) Get(name string) *Group {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.groups[name]
+}
+
+// Destroy removes the group named name and releases its memory: the
+// periodic snapshot saves a last time and stops, background reloads are
+// canceled and the caches are emptied. The group still loads values for
+// callers holding it but caches none. It reports whether the group existed
+func (r *Registry) Destroy(name string) bool {
+	r.lock.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.lock.Unlock()
+
+	if ok {
+		g.close()
+	}
+	return ok
+}
+
+// Groups returns every group sorted by name
+func (r *Registry) Groups() []*Group {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	list := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// List returns the name and stats of every group sorted by name
+func (r *Registry) List() []GroupInfo {
+	groups := r.Groups()
+	infos := make([]GroupInfo, len(groups))
+	for i, g := range groups {
+		infos[i] = GroupInfo{
+			Name:      g.name,
+			Stats:     g.Stats(),
+			MainCache: g.CacheStats(MainCache),
+			HotCache:  g.CacheStats(HotCache),
+		}
+	}
+	return infos
+}
+
+// NewGroup creates a group in DefaultRegistry, it panics if name is taken
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...*Option) *Group {
+	g, err := DefaultRegistry.NewGroup(name, cacheBytes, getter, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func GetGroup(name string) *Group {
+	return DefaultRegistry.Get(name)
+}
+
+// DestroyGroup removes a group of DefaultRegistry, see Registry.Destroy
+func DestroyGroup(name string) bool {
+	return DefaultRegistry.Destroy(name)
+}
+
+// ListGroups returns the groups of DefaultRegistry, see Registry.List
+func ListGroups() []GroupInfo {
+	return DefaultRegistry.List()
+}
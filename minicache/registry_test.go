@@ -0,0 +1,104 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	b, err := r.NewGroup("b", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewGroup("b", 2<<10, getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+	if g, created := r.GetOrCreate("b", 2<<10, getter); created || g != b {
+		t.Fatalf("expect the existing group")
+	}
+	if _, created := r.GetOrCreate("a", 2<<10, getter); !created {
+		t.Fatalf("expect a created")
+	}
+	if GetGroup("b") == b {
+		t.Fatalf("groups of a registry should not be in DefaultRegistry")
+	}
+
+	b.Get("k")
+	list := r.List()
+	if len(list) != 2 || list[0].Name != "a" || list[1].Name != "b" || list[1].Stats.Gets != 1 || list[1].MainCache.Items != 1 {
+		t.Fatalf("unexpected list %+v", list)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("NewGroup should panic on a duplicate name")
+			}
+		}()
+		NewGroup("registry-dup", 2<<10, getter)
+		defer DestroyGroup("registry-dup")
+		NewGroup("registry-dup", 2<<10, getter)
+	}()
+}
+
+func TestRegistryDestroy(t *testing.T) {
+	r := NewRegistry()
+	budget := NewBudget(1 << 20)
+	g, _ := r.NewGroup("destroy", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &Option{Budget: budget, SoftTTL: time.Millisecond})
+	g.Get("k1")
+	g.hotCache.add("k2", ByteView{b: []byte("v2"), e: time.Now().Add(time.Hour)})
+	if budget.Used() == 0 {
+		t.Fatalf("expect the entries charged to the budget")
+	}
+
+	if !r.Destroy("destroy") || r.Destroy("destroy") {
+		t.Fatalf("expect the group destroyed once")
+	}
+	if r.Get("destroy") != nil || budget.Used() != 0 || len(budget.groups) != 0 {
+		t.Fatalf("expect the group and its memory released, %d bytes used", budget.Used())
+	}
+	if g.ctx.Err() == nil || g.hotCache.done != nil {
+		t.Fatalf("expect the background work stopped")
+	}
+
+	// callers still holding the group load values without caching them
+	if v, err := g.Get("k3"); err != nil || v.String() != "k3" {
+		t.Fatalf("unexpected value %v %v", v, err)
+	}
+	if s := g.CacheStats(MainCache); s.Items != 0 {
+		t.Fatalf("a destroyed group should not cache, got %d items", s.Items)
+	}
+}
+
+func TestHTTPPoolRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.NewGroup("tenant", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("tenant-" + key), nil
+	}))
+	srv := httptest.NewServer(NewHTTPPool("self", &PoolOption{Registry: r}))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "tenant", Key: "k"}, res); err != nil || string(res.GetValue()) != "tenant-k" {
+		t.Fatalf("unexpected response %v %v", res, err)
+	}
+	NewGroup("registry-default", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("registry-default")
+	if err := peer.Get(context.Background(), &pb.Request{Group: "registry-default", Key: "k"}, res); err == nil {
+		t.Fatalf("groups of DefaultRegistry should not be served")
+	}
+}
@@ -0,0 +1,141 @@
+package minicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/consistenthash"
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+// flakyPeer is a fakePeer that can be taken down
+type flakyPeer struct {
+	fakePeer
+	down  bool
+	calls int
+}
+
+func (p *flakyPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.calls++
+	if p.down {
+		return errors.New("connection refused")
+	}
+	return p.fakePeer.Get(ctx, in, out)
+}
+
+func (p *flakyPeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.calls++
+	if p.down {
+		return errors.New("connection refused")
+	}
+	return p.fakePeer.GetMany(ctx, in, out)
+}
+
+func TestReplicaFailover(t *testing.T) {
+	flaky := map[string]*flakyPeer{}
+	set := newPeerSet("self", func(addr string) PeerGetter {
+		flaky[addr] = &flakyPeer{fakePeer: fakePeer{values: map[string][]byte{}}}
+		return flaky[addr]
+	}, t.Logf, &PoolOption{Picker: consistenthash.NewRendezvous(nil), Owners: 2})
+	set.Set("self", "a", "b", "c")
+
+	// find a key owned by two peers other than self
+	var key string
+	var owners []PeerGetter
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("key%d", i)
+		if peers, isOwner := set.PickPeers(key); !isOwner {
+			owners = peers
+			break
+		}
+	}
+	first, second := owners[0].(*peer), owners[1].(*peer)
+	flaky[first.addr].values[key] = []byte("first")
+	flaky[second.addr].values[key] = []byte("second")
+
+	g := NewGroup("replica-failover", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local"), nil
+	}))
+	defer DestroyGroup("replica-failover")
+	g.RegisterPeers(set)
+
+	flaky[first.addr].down = true
+	if view, err := g.Get(key); err != nil || view.String() != "second" {
+		t.Fatalf("expect the second replica to serve, got %s %v", view, err)
+	}
+	ps := g.PeerStats()
+	if ps[first.addr].Errors != 1 || ps[second.addr].Loads != 1 {
+		t.Fatalf("unexpected peer stats %+v", ps)
+	}
+
+	g.hotCache.remove(key)
+	flaky[second.addr].down = true
+	if view, err := g.Get(key); err != nil || view.String() != "local" {
+		t.Fatalf("expect a local load once every owner failed, got %s %v", view, err)
+	}
+	if _, ok := g.mainCache.get(key); ok {
+		t.Fatalf("value of a key owned by peers should not be kept in the main cache")
+	}
+
+	// batches fail over the same way
+	g.removeCache(key)
+	flaky[second.addr].down = false
+	if values, err := g.GetMany([]string{key}); err != nil || values[key].String() != "second" {
+		t.Fatalf("expect the second replica to serve the batch, got %v %v", values, err)
+	}
+	g.removeCache(key)
+	flaky[second.addr].down = true
+	if values, err := g.GetMany([]string{key}); err != nil || values[key].String() != "local" {
+		t.Fatalf("expect a local load once every owner failed the batch, got %v %v", values, err)
+	}
+}
+
+func TestPeerBreaker(t *testing.T) {
+	defer func(backoff time.Duration) { breakerBackoff = backoff }(breakerBackoff)
+	breakerBackoff = 20 * time.Millisecond
+
+	flaky := &flakyPeer{fakePeer: fakePeer{values: map[string][]byte{"k": []byte("v")}}, down: true}
+	p := newPeer("a", flaky)
+	ctx := context.Background()
+	get := func() error {
+		return p.Get(ctx, &pb.Request{Key: "k"}, &pb.Response{})
+	}
+
+	for i := 0; i < breakerThreshold; i++ {
+		if err := get(); err == nil {
+			t.Fatalf("expect the peer to fail")
+		}
+	}
+	if p.healthy() {
+		t.Fatalf("peer should be unhealthy after %d failures", breakerThreshold)
+	}
+	if err := get(); !errors.Is(err, ErrPeerUnhealthy) || flaky.calls != breakerThreshold {
+		t.Fatalf("open breaker should fail fast, got %v after %d calls", err, flaky.calls)
+	}
+
+	// the probe after the backoff fails and doubles it
+	time.Sleep(breakerBackoff)
+	if err := get(); err == nil || errors.Is(err, ErrPeerUnhealthy) {
+		t.Fatalf("expect a probe, got %v", err)
+	}
+	if p.backoff != 2*breakerBackoff {
+		t.Fatalf("expect the backoff to double, got %v", p.backoff)
+	}
+
+	flaky.down = false
+	time.Sleep(p.backoff)
+	if err := get(); err != nil || !p.healthy() {
+		t.Fatalf("successful probe should close the breaker, got %v", err)
+	}
+
+	// errors the peer answered with do not count
+	for i := 0; i < breakerThreshold; i++ {
+		p.done(ctx, &replyError{errors.New("not found")})
+	}
+	if !p.healthy() {
+		t.Fatalf("replies should not open the breaker")
+	}
+}
@@ -0,0 +1,157 @@
+package minicache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+	"github.com/qingants/pandora/minirpc"
+)
+
+const rpcServiceName = "GroupCache"
+
+// GroupCache is the minirpc service answering peers of an RPCPool,
+// it implements the GroupCache service declared in pb.proto
+type GroupCache struct {
+	registry *Registry
+}
+
+func (s *GroupCache) Get(in *pb.Request, out *pb.Response) error {
+	group := s.registry.Get(in.GetGroup())
+	if group == nil {
+		return fmt.Errorf("no such group: %s", in.GetGroup())
+	}
+	group.stats.serverRequests.Add(1)
+	view, err := group.get(context.Background(), in.GetKey())
+	return group.fillResponse(out, view, err, in.GetAcceptEncoding())
+}
+
+func (s *GroupCache) Set(in *pb.SetRequest, out *pb.Response) error {
+	group := s.registry.Get(in.GetGroup())
+	if group == nil {
+		return fmt.Errorf("no such group: %s", in.GetGroup())
+	}
+	group.setLocally(in.GetKey(), in.GetValue(), fromUnixNano(in.GetExpire()))
+	return nil
+}
+
+func (s *GroupCache) Remove(in *pb.RemoveRequest, out *pb.Response) error {
+	group := s.registry.Get(in.GetGroup())
+	if group == nil {
+		return fmt.Errorf("no such group: %s", in.GetGroup())
+	}
+	group.removeCache(in.GetKey())
+	return nil
+}
+
+func (s *GroupCache) GetMany(in *pb.BatchRequest, out *pb.BatchResponse) error {
+	group := s.registry.Get(in.GetGroup())
+	if group == nil {
+		return fmt.Errorf("no such group: %s", in.GetGroup())
+	}
+	group.stats.serverRequests.Add(int64(len(in.GetKeys())))
+	values, err := group.getMany(context.Background(), in.GetKeys())
+	out.Entries = batchResponse(in.GetKeys(), values, err, in.GetAcceptEncoding()).GetEntries()
+	return nil
+}
+
+// RPCPool picks peers like HTTPPool but talks to them over minirpc,
+// keeping one multiplexed client connection per peer.
+// Peer addresses are in the minirpc.XDial format, eg tcp@127.0.0.1:8001
+type RPCPool struct {
+	self string
+	opt  *minirpc.Option
+	*peerSet
+}
+
+// NewRPCPool registers the GroupCache service on server, peers dial it with opt
+func NewRPCPool(self string, server *minirpc.Server, opt *minirpc.Option, opts ...*PoolOption) (*RPCPool, error) {
+	p := &RPCPool{self: self, opt: opt}
+	timeout := peerTimeout(opts)
+	p.peerSet = newPeerSet(self, func(addr string) PeerGetter {
+		return &rpcGetter{addr: addr, opt: p.opt, timeout: timeout}
+	}, p.Log, opts...)
+	if err := server.Register(&GroupCache{registry: p.registry}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *RPCPool) Log(format string, v ...any) {
+	log.Printf("[rpc server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+var _ PeerPicker = (*RPCPool)(nil)
+
+type rpcGetter struct {
+	addr    string
+	opt     *minirpc.Option
+	timeout time.Duration // bounds every call if not 0
+	lock    sync.Mutex
+	client  *minirpc.Client
+}
+
+// dial returns the cached client, reconnecting if the connection broke
+func (r *rpcGetter) dial() (*minirpc.Client, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.client != nil && !r.client.IsAvailable() {
+		_ = r.client.Close()
+		r.client = nil
+	}
+	if r.client == nil {
+		client, err := minirpc.XDial(r.addr, r.opt)
+		if err != nil {
+			return nil, err
+		}
+		r.client = client
+	}
+	return r.client, nil
+}
+
+func (r *rpcGetter) call(ctx context.Context, method string, in, out any) error {
+	client, err := r.dial()
+	if err != nil {
+		return err
+	}
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	return asReplyError(client.Call(ctx, rpcServiceName+"."+method, in, out))
+}
+
+func (r *rpcGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return r.call(ctx, "Get", in, out)
+}
+
+func (r *rpcGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	return r.call(ctx, "Set", in, out)
+}
+
+func (r *rpcGetter) Remove(ctx context.Context, in *pb.RemoveRequest, out *pb.Response) error {
+	return r.call(ctx, "Remove", in, out)
+}
+
+func (r *rpcGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return r.call(ctx, "GetMany", in, out)
+}
+
+func (r *rpcGetter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.client == nil {
+		return nil
+	}
+	err := r.client.Close()
+	r.client = nil
+	return err
+}
+
+var _ PeerGetter = (*rpcGetter)(nil)
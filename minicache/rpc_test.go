@@ -0,0 +1,93 @@
+package minicache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+	"github.com/qingants/pandora/minirpc"
+)
+
+func startRPCPool(t *testing.T) (*RPCPool, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := minirpc.NewServer()
+	addr := "tcp@" + l.Addr().String()
+	pool, err := NewRPCPool(addr, server, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() {
+		l.Close()
+		pool.Close()
+	})
+	return pool, addr
+}
+
+func TestRPCPool(t *testing.T) {
+	g := NewGroup("rpc", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "unknow" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("db-" + key), nil
+	}))
+	defer DestroyGroup("rpc")
+	_, addr := startRPCPool(t)
+	peer := &rpcGetter{addr: addr}
+	defer peer.Close()
+
+	for i := 0; i < 3; i++ {
+		view, err := g.GetFromPeer(peer, "rocky")
+		if err != nil || view.String() != "db-rocky" {
+			t.Fatalf("get rocky over rpc = %s, %v", view, err)
+		}
+	}
+	if _, err := g.GetFromPeer(peer, "unknow"); err == nil {
+		t.Fatalf("expect error for unknow key")
+	}
+
+	expire := time.Now().Add(time.Hour).Truncate(time.Second)
+	req := &pb.SetRequest{Group: g.name, Key: "amy", Value: []byte("pushed"), Expire: expire.UnixNano()}
+	if err := peer.Set(context.Background(), req, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := g.GetFromPeer(peer, "amy"); err != nil || view.String() != "pushed" || !view.Expire().Equal(expire) {
+		t.Fatalf("get amy after set = %s %v, %v", view, view.Expire(), err)
+	}
+	if err := peer.Remove(context.Background(), &pb.RemoveRequest{Group: g.name, Key: "amy"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := g.GetFromPeer(peer, "amy"); view.String() != "db-amy" {
+		t.Fatalf("expect amy reloaded after remove, got %s", view)
+	}
+	values, errs, err := g.getManyFromPeer(context.Background(), peer, []string{"rocky", "unknow"})
+	if err != nil || values["rocky"].String() != "db-rocky" || errs["unknow"] == nil {
+		t.Fatalf("get many over rpc = %v %v, %v", values, errs, err)
+	}
+	if s := g.Stats(); s.ServerRequests != 8 {
+		t.Fatalf("expect 8 server requests, got %d", s.ServerRequests)
+	}
+}
+
+func TestRPCPoolPickPeer(t *testing.T) {
+	pool, addr := startRPCPool(t)
+	pool.Set(addr, "tcp@127.0.0.1:1")
+	if len(pool.GetAll()) != 1 {
+		t.Fatalf("GetAll should skip self")
+	}
+	picked := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := pool.PickPeer(fmt.Sprintf("key%d", i)); ok {
+			picked++
+		}
+	}
+	if picked == 0 || picked == 100 {
+		t.Fatalf("expect keys split between self and peer, %d picked", picked)
+	}
+}
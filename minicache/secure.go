@@ -0,0 +1,156 @@
+package minicache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	timestampHeader = "X-Minicache-Timestamp"
+	signatureHeader = "X-Minicache-Signature"
+)
+
+// signatureWindow is how far the timestamp of a signed request may be
+// from the clock of the peer checking it, a captured request can be
+// replayed for that long
+var signatureWindow = 5 * time.Minute
+
+var ErrBadSignature = errors.New("minicache: bad request signature")
+
+// NewPeerTLSConfig loads the certificate and key of a node and the CA its
+// peers' certificates are signed by. The config is meant for both ends:
+// set it as PoolOption.TLSConfig to dial peers and as the TLSConfig of the
+// http.Server serving the pool, which then requires peers to present a
+// certificate signed by the CA
+func NewPeerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	ca := x509.NewCertPool()
+	if !ca.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca,
+		ClientCAs:    ca,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// newPeerClient is the client of an HTTPPool dialing peers with config,
+// giving up on requests taking longer than timeout if it is not 0
+func newPeerClient(config *tls.Config, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if config != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = config.Clone()
+		client.Transport = t
+	}
+	return client
+}
+
+// SignRequest signs req, whose body is body, for an HTTPPool created
+// with secret as PoolOption.Secret. Peers of such a pool sign their
+// requests themselves, it is for other tools talking to the pool
+func SignRequest(req *http.Request, body, secret []byte) {
+	signAt(req, body, secret, time.Now())
+}
+
+func signAt(req *http.Request, body, secret []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, hex.EncodeToString(signature(secret, req.Method, req.URL.RequestURI(), ts, body)))
+}
+
+// signatureHeaders returns the timestamp and signature SignRequest put
+// on r, checking the timestamp is within the window
+func signatureHeaders(r *http.Request) (ts string, sent []byte, err error) {
+	ts = r.Header.Get(timestampHeader)
+	sent, err = hex.DecodeString(r.Header.Get(signatureHeader))
+	if ts == "" || err != nil || len(sent) == 0 {
+		return "", nil, errMissingSignature
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: bad timestamp", ErrBadSignature)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > signatureWindow || skew < -signatureWindow {
+		return "", nil, &skewError{skew}
+	}
+	return ts, sent, nil
+}
+
+var errMissingSignature = fmt.Errorf("%w: missing", ErrBadSignature)
+
+// skewError is returned for signed requests outside of the signature window
+type skewError struct {
+	skew time.Duration
+}
+
+func (e *skewError) Error() string {
+	return fmt.Sprintf("%v: timestamp off by %v", ErrBadSignature, e.skew.Round(time.Second))
+}
+
+func (e *skewError) Is(target error) bool {
+	return target == ErrBadSignature
+}
+
+// VerifyRequest checks the signature SignRequest put on r, for servers
+// besides an HTTPPool sharing its secret. The body, of at most 64MB,
+// is read and left in r for the handler
+func VerifyRequest(r *http.Request, secret []byte) error {
+	if _, _, err := signatureHeaders(r); err != nil {
+		return err
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r.Body, maxRequestBytes+1)); err != nil {
+			return err
+		}
+		if int64(len(body)) > maxRequestBytes {
+			return fmt.Errorf("request body over %d bytes", maxRequestBytes)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return verifyRequest(r, body, secret)
+}
+
+// verifyRequest checks the signature SignRequest put on r
+func verifyRequest(r *http.Request, body, secret []byte) error {
+	ts, sent, err := signatureHeaders(r)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(sent, signature(secret, r.Method, r.URL.RequestURI(), ts, body)) {
+		return fmt.Errorf("%w: mismatch", ErrBadSignature)
+	}
+	return nil
+}
+
+// signature is the HMAC-SHA256 of the method, URI, timestamp and the
+// SHA-256 of the body, one per line
+func signature(secret []byte, method, uri, ts string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%x", method, uri, ts, sum)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,202 @@
+package minicache
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+// writeCert writes a certificate for 127.0.0.1 signed by parent, or self
+// signed as a CA if parent is nil, and returns it with its key
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(file, typ string, b []byte) {
+		if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(name+".pem", "CERTIFICATE", der)
+	write(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestPeerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "node", ca, caKey)
+	config, err := NewPeerTLSConfig(filepath.Join(dir, "node.pem"), filepath.Join(dir, "node-key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	r.NewGroup("tls", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("secret-" + key), nil
+	}))
+	opt := &PoolOption{Registry: r, TLSConfig: config}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+	srv.Config.Handler = NewHTTPPool(srv.URL, opt)
+
+	client := NewHTTPPool("https://127.0.0.1:1", opt)
+	client.Set(srv.URL)
+	peer, ok := client.PickPeer("k")
+	if !ok {
+		t.Fatalf("expect the server to own every key")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "tls", Key: "k"}, res); err != nil || string(res.GetValue()) != "secret-k" {
+		t.Fatalf("unexpected response %v %v", res, err)
+	}
+
+	// a client without a certificate signed by the CA is turned away
+	anonymous := &httpGetter{baseURL: srv.URL + defaultBasePath, client: newPeerClient(&tls.Config{RootCAs: config.RootCAs}, time.Second)}
+	if err := anonymous.Get(context.Background(), &pb.Request{Group: "tls", Key: "k"}, res); err == nil {
+		t.Fatalf("expect the handshake to fail without a client certificate")
+	}
+
+	// so is a request that reached the pool over plain http
+	plain := httptest.NewServer(NewHTTPPool("self", opt))
+	defer plain.Close()
+	if err := (&httpGetter{baseURL: plain.URL + defaultBasePath}).Get(context.Background(), &pb.Request{Group: "tls", Key: "k"}, res); err == nil {
+		t.Fatalf("expect a request without a client certificate refused")
+	}
+}
+
+func TestRequestSigning(t *testing.T) {
+	r := NewRegistry()
+	r.NewGroup("signed", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	secret := []byte("shared secret")
+	srv := httptest.NewServer(NewHTTPPool("self", &PoolOption{Registry: r, Secret: secret}))
+	defer srv.Close()
+	base := srv.URL + defaultBasePath
+
+	ctx := context.Background()
+	signed := &httpGetter{baseURL: base, secret: secret}
+	res := &pb.Response{}
+	if err := signed.Get(ctx, &pb.Request{Group: "signed", Key: "k"}, res); err != nil || string(res.GetValue()) != "k" {
+		t.Fatalf("unexpected response %v %v", res, err)
+	}
+	if err := signed.Set(ctx, &pb.SetRequest{Group: "signed", Key: "k", Value: []byte("v")}, res); err != nil {
+		t.Fatalf("signed set failed %v", err)
+	}
+	batch := &pb.BatchResponse{}
+	if err := signed.GetMany(ctx, &pb.BatchRequest{Group: "signed", Keys: []string{"k"}}, batch); err != nil || len(batch.GetEntries()) != 1 {
+		t.Fatalf("signed batch failed %v %v", batch, err)
+	}
+
+	for name, getter := range map[string]*httpGetter{
+		"unsigned":     {baseURL: base},
+		"wrong secret": {baseURL: base, secret: []byte("guess")},
+	} {
+		err := getter.Get(ctx, &pb.Request{Group: "signed", Key: "k"}, res)
+		var reply *replyError
+		if !errors.As(err, &reply) {
+			t.Fatalf("%s: expect the request refused, got %v", name, err)
+		}
+	}
+
+	// metrics need the signature too
+	for name, sign := range map[string]bool{"unsigned": false, "signed": true} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+defaultMetricsPath, nil)
+		if sign {
+			SignRequest(req, nil, secret)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if want := map[bool]int{false: http.StatusUnauthorized, true: http.StatusOK}[sign]; res.StatusCode != want {
+			t.Fatalf("%s metrics: expect %d, got %d", name, want, res.StatusCode)
+		}
+	}
+
+	// an oversized body is refused, and an unsigned one is not even read
+	defer func(n int64) { maxRequestBytes = n }(maxRequestBytes)
+	maxRequestBytes = 16
+	large := bytes.Repeat([]byte("x"), 64)
+	for name, sign := range map[string]bool{"unsigned": false, "signed": true} {
+		req, _ := http.NewRequest(http.MethodPut, base+"signed/k", bytes.NewReader(large))
+		if sign {
+			SignRequest(req, large, secret)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if want := map[bool]int{false: http.StatusUnauthorized, true: http.StatusRequestEntityTooLarge}[sign]; res.StatusCode != want {
+			t.Fatalf("%s large body: expect %d, got %d", name, want, res.StatusCode)
+		}
+	}
+
+	// a captured request replayed after the window is refused, though
+	// its signature is valid
+	req, _ := http.NewRequest(http.MethodGet, base+"signed/k", nil)
+	signAt(req, nil, secret, time.Now().Add(-2*signatureWindow))
+	var skew *skewError
+	if err := verifyRequest(req, nil, secret); !errors.As(err, &skew) || !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expect a stale signature refused for its timestamp, got %v", err)
+	}
+	signAt(req, nil, secret, time.Now().Add(-signatureWindow/2))
+	if err := verifyRequest(req, nil, secret); err != nil {
+		t.Fatalf("expect a signature within the window accepted, got %v", err)
+	}
+	// and so is a signed request whose body was changed
+	SignRequest(req, []byte("body"), secret)
+	if err := verifyRequest(req, []byte("other"), secret); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expect a changed body refused, got %v", err)
+	}
+}
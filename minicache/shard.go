@@ -0,0 +1,109 @@
+package minicache
+
+// shardedCache splits keys by hash over caches with their own lock and
+// byte budget, so concurrent gets of different keys rarely contend
+type shardedCache struct {
+	shards []*cache
+}
+
+func newShardedCache(n int, cacheBytes int64, policyType PolicyType, mem *memAccount) *shardedCache {
+	if n < 1 {
+		n = 1
+	}
+	shardBytes := cacheBytes / int64(n)
+	if cacheBytes != 0 && shardBytes == 0 {
+		shardBytes = 1
+	}
+	sc := &shardedCache{shards: make([]*cache, n)}
+	for i := range sc.shards {
+		sc.shards[i] = &cache{
+			cacheBytes: shardBytes,
+			policyType: policyType,
+			overhead:   entryOverhead(policyType),
+			mem:        mem,
+		}
+	}
+	return sc
+}
+
+func (sc *shardedCache) shard(key string) *cache {
+	if len(sc.shards) == 1 {
+		return sc.shards[0]
+	}
+	return sc.shards[fnv32a(key)%uint32(len(sc.shards))]
+}
+
+// fnv32a hashes key without the allocations of hash/fnv
+func fnv32a(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (sc *shardedCache) add(key string, value ByteView) {
+	sc.shard(key).add(key, value)
+}
+
+func (sc *shardedCache) get(key string) (ByteView, bool) {
+	return sc.shard(key).get(key)
+}
+
+func (sc *shardedCache) remove(key string) {
+	sc.shard(key).remove(key)
+}
+
+func (sc *shardedCache) removeExpired() int {
+	n := 0
+	for _, c := range sc.shards {
+		n += c.removeExpired()
+	}
+	return n
+}
+
+func (sc *shardedCache) entries() []snapshotEntry {
+	var entries []snapshotEntry
+	for _, c := range sc.shards {
+		entries = append(entries, c.entries()...)
+	}
+	return entries
+}
+
+func (sc *shardedCache) clear() {
+	for _, c := range sc.shards {
+		c.clear()
+	}
+}
+
+func (sc *shardedCache) close() {
+	for _, c := range sc.shards {
+		c.close()
+	}
+}
+
+// disuse evicts an entry from the shard taking the most bytes
+func (sc *shardedCache) disuse() bool {
+	var largest *cache
+	var most int64
+	for _, c := range sc.shards {
+		if b := c.bytes(); b > most {
+			largest, most = c, b
+		}
+	}
+	return largest != nil && largest.disuse()
+}
+
+func (sc *shardedCache) stats() CacheStats {
+	var s CacheStats
+	for _, c := range sc.shards {
+		cs := c.stats()
+		s.Bytes += cs.Bytes
+		s.Items += cs.Items
+		s.Gets += cs.Gets
+		s.Hits += cs.Hits
+		s.Evictions += cs.Evictions
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package minicache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestShardedCache(t *testing.T) {
+	sc := newShardedCache(4, 4000, LRU, nil)
+	for i := 0; i < 1000; i++ {
+		sc.add(fmt.Sprintf("key%d", i), ByteView{b: []byte("0123456789")})
+	}
+	for i, c := range sc.shards {
+		if s := c.stats(); s.Items == 0 || s.Bytes > 1000 {
+			t.Fatalf("shard %d holds %d items in %d bytes", i, s.Items, s.Bytes)
+		}
+	}
+	if s := sc.stats(); s.Bytes > 4000 {
+		t.Fatalf("sharded cache over budget with %d bytes", s.Bytes)
+	}
+
+	sc.add("rocky", ByteView{b: []byte("handsome")})
+	if v, ok := sc.get("rocky"); !ok || v.String() != "handsome" {
+		t.Fatalf("cache hit rocky=handsome failed")
+	}
+	sc.remove("rocky")
+	if _, ok := sc.get("rocky"); ok {
+		t.Fatalf("rocky should be removed")
+	}
+}
+
+func TestGroupShards(t *testing.T) {
+	g := NewGroup("shards", 64<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &Option{Shards: 8})
+	defer DestroyGroup("shards")
+	if len(g.mainCache.shards) != 8 {
+		t.Fatalf("expect 8 shards, got %d", len(g.mainCache.shards))
+	}
+	for i := 0; i < 20; i++ {
+		g.Get(fmt.Sprintf("key%d", i))
+	}
+	if s := g.CacheStats(MainCache); s.Items != 20 {
+		t.Fatalf("expect 20 items over all shards, got %d", s.Items)
+	}
+}
+
+func BenchmarkShardedCacheParallel(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	for _, n := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", n), func(b *testing.B) {
+			sc := newShardedCache(n, 1<<20, LRU, nil)
+			for _, key := range keys {
+				sc.add(key, ByteView{b: []byte(key)})
+			}
+
+			value := ByteView{b: make([]byte, 64)}
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Int()
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%10 == 0 {
+						sc.add(key, value)
+					} else {
+						sc.get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -1,13 +1,68 @@
 package singlefight
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
+// errGoexit is the result of calls whose fn called runtime.Goexit
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// panicError is the result of calls whose fn panicked, it is panicked
+// again in every caller waiting on the call
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+func (p *panicError) Unwrap() error {
+	err, ok := p.value.(error)
+	if !ok {
+		return nil
+	}
+	return err
+}
+
+func newPanicError(v any) error {
+	stack := debug.Stack()
+	// drop the first line, the goroutine header, the stack of the waiter
+	// panicking again is not that of this goroutine
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &panicError{value: v, stack: stack}
+}
+
 type call struct {
-	wg  sync.WaitGroup
-	val any
-	err error
+	done chan struct{}
+	val  any
+	err  error
+
+	dups  int
+	chans []chan<- Result
+
+	// set for calls started by DoDetached, cancel stops fn once
+	// all of the waiting callers gave up
+	detached bool
+	waiters  int
+	cancel   context.CancelFunc
+}
+
+// Result is sent on the channel returned by DoChan
+type Result struct {
+	Val    any
+	Err    error
+	Shared bool // the result went to more than one caller
 }
 
 type Group struct {
@@ -15,29 +70,225 @@ type Group struct {
 	m  map[string]*call
 }
 
-func (g *Group) Do(key string, fn func() (any, error)) (any, error) {
+// Do runs fn once for all the callers asking for key at the same time,
+// shared tells if the result was given to more than one of them. If fn
+// panics or calls runtime.Goexit, so do all the callers
+func (g *Group) Do(key string, fn func() (any, error)) (v any, err error, shared bool) {
+	return g.DoContext(context.Background(), key, fn)
+}
+
+// DoContext is like Do but a caller waiting on another in-flight call
+// gives up once ctx is done, the call keeps running for the others
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (any, error)) (v any, err error, shared bool) {
 	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err(), false
+		}
+		if e, ok := c.err.(*panicError); ok {
+			panic(e)
+		} else if c.err == errGoexit {
+			runtime.Goexit()
+		}
+		return c.val, c.err, true
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	g.doCall(c, key, fn)
+	return c.val, c.err, c.dups > 0
+}
+
+// DoDetached is like DoContext but fn runs in a goroutine of its own with
+// a context keeping the values of the first caller's ctx but not its
+// deadline or cancellation, so the first caller giving up does not fail
+// the others. Every caller stops waiting once its own ctx is done, fn's
+// context is canceled when all of them have
+func (g *Group) DoDetached(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (v any, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	c, ok := g.m[key]
+	if ok {
+		c.dups++
+	} else {
+		callCtx, cancel := context.WithCancel(detached{ctx})
+		c = &call{done: make(chan struct{}), detached: true, cancel: cancel}
+		g.m[key] = c
+		go g.doCall(c, key, func() (any, error) { return fn(callCtx) })
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 && c.cancel != nil {
+			// nobody waits for the result, later callers start over
+			c.cancel()
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err(), false
+	}
+	if e, ok := c.err.(*panicError); ok {
+		panic(e)
+	} else if c.err == errGoexit {
+		runtime.Goexit()
+	}
+	return c.val, c.err, c.dups > 0
+}
+
+// detached keeps the values of a context but is never done
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
 
+// DoChan is like Do but returns a channel receiving the result once it
+// is ready. If fn panics the process crashes, the panic can not be
+// delivered on the channel
+func (g *Group) DoChan(key string, fn func() (any, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
 		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		return ch
 	}
 
-	c := new(call)
-	c.wg.Add(1)
+	c := &call{done: make(chan struct{}), chans: []chan<- Result{ch}}
 	g.m[key] = c
 	g.mu.Unlock()
 
-	c.val, c.err = fn()
-	c.wg.Done()
+	go g.doCall(c, key, fn)
+	return ch
+}
 
+// Begin registers a call for key run by the caller itself, as when it
+// loads many keys at once, and returns the func handing the result to
+// the callers waiting on key. It returns nil if a call for key is in
+// flight already. The func must be called, later calls are ignored
+func (g *Group) Begin(key string) (finish func(v any, err error)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if _, ok := g.m[key]; ok {
+		return nil
+	}
+	c := &call{done: make(chan struct{})}
+	g.m[key] = c
+
+	var once sync.Once
+	return func(v any, err error) {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			c.val, c.err = v, err
+			close(c.done)
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+			for _, ch := range c.chans {
+				ch <- Result{v, err, c.dups > 0}
+			}
+		})
+	}
+}
+
+// dups returns how many callers joined the call in flight for key
+func (g *Group) dups(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.m[key]; ok {
+		return c.dups
+	}
+	return 0
+}
+
+// Forget makes the next call for key run fn again instead of waiting
+// on the one in flight, whose callers still get its result
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
+}
+
+func (g *Group) doCall(c *call, key string, fn func() (any, error)) {
+	normalReturn := false
+	recovered := false
+
+	// the deferred func runs for panics and runtime.Goexit alike,
+	// normalReturn and recovered tell them apart
+	defer func() {
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
 
-	return c.val, c.err
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		close(c.done)
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		if c.cancel != nil {
+			c.cancel()
+		}
+
+		if e, ok := c.err.(*panicError); ok {
+			if c.detached && c.waiters > 0 {
+				// fn ran in a goroutine of its own, the waiters panic with e
+				return
+			}
+			if len(c.chans) > 0 {
+				// keep the panic from being recovered by nobody waiting on it
+				go panic(e)
+				select {}
+			}
+			panic(e)
+		} else if c.err == errGoexit {
+			// the goroutine is already exiting
+		} else {
+			for _, ch := range c.chans {
+				ch <- Result{c.val, c.err, c.dups > 0}
+			}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
 }
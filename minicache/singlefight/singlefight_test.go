@@ -0,0 +1,311 @@
+package singlefight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (any, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v", v, err, shared)
+	}
+
+	want := errors.New("failed")
+	if _, err, _ = g.Do("key", func() (any, error) { return nil, want }); err != want {
+		t.Fatalf("Do error = %v, want %v", err, want)
+	}
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func() (any, error) {
+		calls.Add(1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg, started sync.WaitGroup
+	var sharedCount atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			v, err, shared := g.Do("key", fn)
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	started.Wait()
+	for g.dups("key") < n-1 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("fn called %d times", calls.Load())
+	}
+	if sharedCount.Load() != n {
+		t.Fatalf("expect every caller to see a shared result, got %d", sharedCount.Load())
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (any, error) {
+		<-release
+		return "bar", nil
+	}
+	ch1 := g.DoChan("key", fn)
+	ch2 := g.DoChan("key", fn)
+	close(release)
+
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		select {
+		case res := <-ch:
+			if res.Val != "bar" || res.Err != nil || !res.Shared {
+				t.Fatalf("DoChan = %+v", res)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("DoChan timed out")
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	first := make(chan struct{})
+	ch1 := g.DoChan("key", func() (any, error) {
+		<-first
+		return 1, nil
+	})
+
+	g.Forget("key")
+	if v, _, shared := g.Do("key", func() (any, error) { return 2, nil }); v != 2 || shared {
+		t.Fatalf("Do after Forget = %v shared %v, expect a new call", v, shared)
+	}
+
+	// a call started after Forget is not dropped by the forgotten one finishing
+	second := make(chan struct{})
+	ch2 := g.DoChan("key", func() (any, error) {
+		<-second
+		return 3, nil
+	})
+	close(first)
+	if res := <-ch1; res.Val != 1 {
+		t.Fatalf("forgotten call = %+v", res)
+	}
+	ch3 := g.DoChan("key", func() (any, error) { return 4, nil })
+	close(second)
+	if res := <-ch2; res.Val != 3 || !res.Shared {
+		t.Fatalf("second call = %+v", res)
+	}
+	if res := <-ch3; res.Val != 3 {
+		t.Fatalf("expect the call to join the one in flight, got %+v", res)
+	}
+}
+
+func TestPanicDo(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (any, error) {
+		<-release
+		panic("boom")
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	var panics atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					var pe *panicError
+					if e, ok := r.(error); ok && errors.As(e, &pe) && pe.value == "boom" {
+						panics.Add(1)
+					}
+				}
+			}()
+			g.Do("key", fn)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if panics.Load() != n {
+		t.Fatalf("expect %d callers to panic, got %d", n, panics.Load())
+	}
+	if v, _, _ := g.Do("key", func() (any, error) { return "ok", nil }); v != "ok" {
+		t.Fatalf("key should be released after a panic")
+	}
+}
+
+func TestGoexitDo(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (any, error) {
+		<-release
+		runtime.Goexit()
+		return nil, nil
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	var returned atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Do("key", fn)
+			returned.Add(1)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if returned.Load() != 0 {
+		t.Fatalf("%d callers returned, expect all to exit", returned.Load())
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ch := g.DoChan("key", func() (any, error) {
+		<-release
+		return "bar", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err, _ := g.DoContext(ctx, "key", nil); err != context.DeadlineExceeded {
+		t.Fatalf("expect the waiter to give up, got %v", err)
+	}
+	close(release)
+	if res := <-ch; res.Val != "bar" {
+		t.Fatalf("call should finish for the others, got %+v", res)
+	}
+}
+
+func TestDoDetached(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (any, error) {
+		close(started)
+		select {
+		case <-release:
+			return "bar", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the first caller giving up does not fail the one still waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoDetached(ctx, "key", fn)
+		first <- err
+	}()
+	<-started
+	second := make(chan any, 1)
+	go func() {
+		v, _, _ := g.DoDetached(context.Background(), "key", nil)
+		second <- v
+	}()
+	for {
+		g.mu.Lock()
+		waiters := g.m["key"].waiters
+		g.mu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		runtime.Gosched()
+	}
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("expect the first caller to give up, got %v", err)
+	}
+	close(release)
+	if v := <-second; v != "bar" {
+		t.Fatalf("expect the second caller to get the result, got %v", v)
+	}
+
+	// once every caller gave up fn is canceled
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stopped := make(chan error, 1)
+	_, err, _ := g.DoDetached(ctx, "other", func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect the caller to give up, got %v", err)
+	}
+	if err := <-stopped; err != context.Canceled {
+		t.Fatalf("expect fn canceled, got %v", err)
+	}
+}
+
+func TestBegin(t *testing.T) {
+	var g Group
+	finish := g.Begin("key")
+	if finish == nil || g.Begin("key") != nil {
+		t.Fatalf("expect a single call registered for key")
+	}
+	ch := g.DoChan("key", func() (any, error) { return "fn", nil })
+	done := make(chan any)
+	go func() {
+		v, _, shared := g.Do("key", func() (any, error) { return "fn", nil })
+		if !shared {
+			t.Errorf("expect the result shared")
+		}
+		done <- v
+	}()
+	for {
+		g.mu.Lock()
+		dups := g.m["key"].dups
+		g.mu.Unlock()
+		if dups == 2 {
+			break
+		}
+		runtime.Gosched()
+	}
+	finish("begun", nil)
+	finish("again", nil)
+	if v := <-done; v != "begun" {
+		t.Fatalf("expect the waiter to get the result of the caller, got %v", v)
+	}
+	if res := <-ch; res.Val != "begun" {
+		t.Fatalf("expect the channel to get the result of the caller, got %+v", res)
+	}
+	if finish = g.Begin("key"); finish == nil {
+		t.Fatalf("expect key released once finished")
+	}
+	finish(nil, nil)
+}
@@ -0,0 +1,252 @@
+package minicache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A snapshot is
+//
+//	magic "MCSNAP" | version uint16 | group name | entry count uvarint
+//	entries: key | value | expire varint, unix nano and 0 for never
+//	crc32 IEEE of everything before it, uint32
+//
+// strings and byte slices are prefixed by their uvarint length, fixed size
+// integers are big endian. Entries are in eviction order so loading them
+// in turn restores the recency of the cache
+const (
+	snapshotMagic   = "MCSNAP"
+	snapshotVersion = 1
+)
+
+var ErrBadSnapshot = errors.New("minicache: bad snapshot")
+
+type snapshotEntry struct {
+	key   string
+	value ByteView
+}
+
+// WriteSnapshot writes the unexpired entries of the main cache to w,
+// the hot cache holds keys owned by peers and is left out
+func (g *Group) WriteSnapshot(w io.Writer) error {
+	entries := g.mainCache.entries()
+
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(snapshotVersion))
+	writeBytes(&buf, []byte(g.name))
+	writeUvarint(&buf, uint64(len(entries)))
+	for _, e := range entries {
+		value, err := e.value.value()
+		if err != nil {
+			return fmt.Errorf("snapshot of %s: %v", e.key, err)
+		}
+		writeBytes(&buf, []byte(e.key))
+		writeBytes(&buf, value)
+		writeVarint(&buf, toUnixNano(e.value.Expire()))
+	}
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadSnapshot adds the entries of a snapshot written by WriteSnapshot to
+// the main cache and returns how many were loaded. Nothing is loaded unless
+// the whole snapshot is valid, expired entries are skipped
+func (g *Group) ReadSnapshot(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := parseSnapshot(data, g.name)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	n := 0
+	for _, e := range entries {
+		if expire := e.value.Expire(); !expire.IsZero() && !now.Before(expire) {
+			continue
+		}
+		g.setCache(e.key, g.withTTL(e.value))
+		n++
+	}
+	return n, nil
+}
+
+func parseSnapshot(data []byte, group string) ([]snapshotEntry, error) {
+	if len(data) < len(snapshotMagic)+2+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: not a snapshot", ErrBadSnapshot)
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+
+	r := bytes.NewReader(body[len(snapshotMagic):])
+	var version uint16
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil || version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, version)
+	}
+	name, err := readBytes(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	if string(name) != group {
+		return nil, fmt.Errorf("%w: snapshot of group %s", ErrBadSnapshot, name)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+
+	var entries []snapshotEntry
+	for i := uint64(0); i < count; i++ {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %v", ErrBadSnapshot, i, err)
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %v", ErrBadSnapshot, i, err)
+		}
+		expire, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %d: %v", ErrBadSnapshot, i, err)
+		}
+		entries = append(entries, snapshotEntry{
+			key:   string(key),
+			value: ByteView{b: value, e: fromUnixNano(expire)},
+		})
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrBadSnapshot, r.Len())
+	}
+	return entries, nil
+}
+
+// SaveSnapshot writes the snapshot to a temporary file renamed to path,
+// so a crash never leaves a partial snapshot behind
+func (g *Group) SaveSnapshot(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = g.WriteSnapshot(w); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// LoadSnapshot reads the snapshot saved at path by SaveSnapshot
+func (g *Group) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return g.ReadSnapshot(bufio.NewReader(f))
+}
+
+// SnapshotEvery saves a snapshot to path every interval until stop is
+// called, which saves a last one
+func (g *Group) SnapshotEvery(path string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				g.saveSnapshot(path)
+				return
+			}
+			g.saveSnapshot(path)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
+
+// warmUp loads the snapshot at opt.SnapshotPath and starts saving it periodically
+func (g *Group) warmUp(opt *Option) {
+	if opt.SnapshotPath == "" {
+		return
+	}
+	if n, err := g.LoadSnapshot(opt.SnapshotPath); err == nil {
+		log.Printf("[MiniCache] Loaded %d entries of %s from snapshot", n, g.name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[MiniCache] Failed to load snapshot of %s %v", g.name, err)
+	}
+	if opt.SnapshotInterval > 0 {
+		g.stopSnapshot = g.SnapshotEvery(opt.SnapshotPath, opt.SnapshotInterval)
+	}
+}
+
+func (g *Group) saveSnapshot(path string) {
+	if err := g.SaveSnapshot(path); err != nil {
+		log.Printf("[MiniCache] Failed to save snapshot of %s %v", g.name, err)
+	}
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
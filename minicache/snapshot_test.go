@@ -0,0 +1,126 @@
+package minicache
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	})
+	for _, policy := range []PolicyType{LRU, LFU, ARC, TinyLFU} {
+		name := "snapshot-" + policyName(policy)
+		g := NewGroup(name, 1<<20, getter, &Option{Policy: policy, Shards: 4})
+		defer DestroyGroup(name)
+		g.setCache("k1", ByteView{b: []byte("v1")})
+		expire := time.Now().Add(time.Hour)
+		g.setCache("k2", ByteView{b: []byte("v2"), e: expire})
+		g.setCache("gone", ByteView{b: []byte("v3"), e: time.Now().Add(-time.Second)})
+		g.hotCache.add("hot", ByteView{b: []byte("v4")})
+
+		var buf bytes.Buffer
+		if err := g.WriteSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		restored, _ := NewRegistry().NewGroup(name, 1<<20, getter, &Option{Policy: policy, Shards: 2})
+		if n, err := restored.ReadSnapshot(&buf); err != nil || n != 2 {
+			t.Fatalf("%s: loaded %d entries, %v", name, n, err)
+		}
+		if v, ok := restored.mainCache.get("k1"); !ok || v.String() != "v1" {
+			t.Fatalf("%s: k1 not restored", name)
+		}
+		if v, ok := restored.mainCache.get("k2"); !ok || !v.Expire().Equal(expire) {
+			t.Fatalf("%s: k2 not restored with its expiry", name)
+		}
+		if _, ok := restored.hotCache.get("hot"); ok {
+			t.Fatalf("%s: hot cache should not be snapshotted", name)
+		}
+	}
+}
+
+func policyName(p PolicyType) string {
+	return [...]string{"lru", "lfu", "arc", "tinylfu"}[p]
+}
+
+func TestSnapshotRecency(t *testing.T) {
+	// room for 3 entries of 4 bytes in the main cache
+	main := 3 * (4 + entryOverhead(LRU))
+	size := int64(main + main/(hotCacheRatio-1))
+	g := NewGroup("snapshot-recency", size, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("vv"), nil
+	}))
+	defer DestroyGroup("snapshot-recency")
+	for _, key := range []string{"k1", "k2", "k3"} {
+		g.setCache(key, ByteView{b: []byte("vv")})
+	}
+	g.mainCache.get("k1")
+
+	var buf bytes.Buffer
+	if err := g.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := NewRegistry().NewGroup("snapshot-recency", size, g.getter)
+	if _, err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored.setCache("k4", ByteView{b: []byte("vv")})
+	if _, ok := restored.mainCache.get("k2"); ok {
+		t.Fatalf("k2 was the least recently used and should be evicted first")
+	}
+	if _, ok := restored.mainCache.get("k1"); !ok {
+		t.Fatalf("k1 was used recently and should be kept")
+	}
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	g := NewGroup("snapshot-invalid", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer DestroyGroup("snapshot-invalid")
+	g.setCache("k1", ByteView{b: []byte("v1")})
+	var buf bytes.Buffer
+	if err := g.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-6] ^= 0xff
+	other := NewGroup("snapshot-other", 2<<10, g.getter)
+	defer DestroyGroup("snapshot-other")
+	cases := map[string]struct {
+		g    *Group
+		data []byte
+	}{
+		"corrupt":   {g, corrupt},
+		"truncated": {g, data[:len(data)-1]},
+		"empty":     {g, nil},
+		"group":     {other, data},
+	}
+	for name, tc := range cases {
+		if n, err := tc.g.ReadSnapshot(bytes.NewReader(tc.data)); !errors.Is(err, ErrBadSnapshot) || n != 0 {
+			t.Errorf("%s: expect ErrBadSnapshot, got %d %v", name, n, err)
+		}
+	}
+}
+
+func TestSnapshotEvery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("db-" + key), nil
+	})
+	g := NewGroup("snapshot-every", 2<<10, getter, &Option{SnapshotPath: path, SnapshotInterval: 10 * time.Millisecond})
+	g.Get("k1")
+	// destroying the group saves a last snapshot
+	DestroyGroup("snapshot-every")
+
+	restored := NewGroup("snapshot-every", 2<<10, getter, &Option{SnapshotPath: path})
+	defer DestroyGroup("snapshot-every")
+	if _, ok := restored.mainCache.get("k1"); !ok {
+		t.Fatalf("expect k1 to be loaded from the snapshot")
+	}
+}
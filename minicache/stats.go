@@ -0,0 +1,120 @@
+package minicache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are per-group counters, a snapshot is returned by Group.Stats
+type Stats struct {
+	Gets           int64 // any Get request, including from peers
+	CacheHits      int64 // either cache was good
+	HotCacheHits   int64 // served from the hot cache
+	NegativeHits   int64 // cache hits on a remembered ErrNotFound
+	StaleHits      int64 // cache hits past the soft TTL
+	Refreshes      int64 // background reloads of stale values
+	Loads          int64 // (gets - cacheHits)
+	LoadsDeduped   int64 // after singlefight
+	PeerLoads      int64 // either remote load or remote cache hit (not an error)
+	PeerErrors     int64
+	LocalLoads     int64 // total good local loads
+	LocalLoadErrs  int64 // total bad local loads
+	ServerRequests int64 // gets that came over the network from peers
+}
+
+type groupStats struct {
+	gets           atomic.Int64
+	cacheHits      atomic.Int64
+	hotCacheHits   atomic.Int64
+	negativeHits   atomic.Int64
+	staleHits      atomic.Int64
+	refreshes      atomic.Int64
+	loads          atomic.Int64
+	loadsDeduped   atomic.Int64
+	peerLoads      atomic.Int64
+	peerErrors     atomic.Int64
+	localLoads     atomic.Int64
+	localLoadErrs  atomic.Int64
+	serverRequests atomic.Int64
+
+	peers sync.Map // peer address to *peerCounters
+}
+
+// PeerStats count the fetches from one peer, returned by Group.PeerStats
+type PeerStats struct {
+	Loads  int64 // values the peer served
+	Errors int64 // failed fetches, including the ones skipped while it was unhealthy
+}
+
+type peerCounters struct {
+	loads  atomic.Int64
+	errors atomic.Int64
+}
+
+func (s *groupStats) peer(addr string) *peerCounters {
+	if c, ok := s.peers.Load(addr); ok {
+		return c.(*peerCounters)
+	}
+	c, _ := s.peers.LoadOrStore(addr, &peerCounters{})
+	return c.(*peerCounters)
+}
+
+func (s *groupStats) peerSnapshot() map[string]PeerStats {
+	stats := make(map[string]PeerStats)
+	s.peers.Range(func(addr, c any) bool {
+		stats[addr.(string)] = PeerStats{
+			Loads:  c.(*peerCounters).loads.Load(),
+			Errors: c.(*peerCounters).errors.Load(),
+		}
+		return true
+	})
+	return stats
+}
+
+func (s *groupStats) snapshot() Stats {
+	return Stats{
+		Gets:           s.gets.Load(),
+		CacheHits:      s.cacheHits.Load(),
+		HotCacheHits:   s.hotCacheHits.Load(),
+		NegativeHits:   s.negativeHits.Load(),
+		StaleHits:      s.staleHits.Load(),
+		Refreshes:      s.refreshes.Load(),
+		Loads:          s.loads.Load(),
+		LoadsDeduped:   s.loadsDeduped.Load(),
+		PeerLoads:      s.peerLoads.Load(),
+		PeerErrors:     s.peerErrors.Load(),
+		LocalLoads:     s.localLoads.Load(),
+		LocalLoadErrs:  s.localLoadErrs.Load(),
+		ServerRequests: s.serverRequests.Load(),
+	}
+}
+
+// CacheType selects one of the caches of a Group
+type CacheType int
+
+const (
+	// MainCache holds the keys this process is the owner of
+	MainCache CacheType = iota + 1
+	// HotCache holds a sample of keys owned by peers
+	HotCache
+)
+
+func (t CacheType) String() string {
+	switch t {
+	case MainCache:
+		return "main"
+	case HotCache:
+		return "hot"
+	default:
+		return "unknown"
+	}
+}
+
+// CacheStats are returned by Group.CacheStats
+type CacheStats struct {
+	Bytes     int64 // keys, values as stored and the estimated overhead of each entry
+	Items     int64
+	Gets      int64
+	Hits      int64
+	Evictions int64 // entries dropped to stay within the byte budget
+}
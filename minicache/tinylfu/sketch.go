@@ -0,0 +1,71 @@
+package tinylfu
+
+import "hash/fnv"
+
+const sketchDepth = 4
+
+// sketch is a count-min sketch with saturating 4-bit style counters.
+// All counters are halved once samples increments were recorded so old
+// popularity fades away.
+type sketch struct {
+	width    uint64
+	counters [sketchDepth][]uint8
+	added    int
+	samples  int
+}
+
+func newSketch(width int) *sketch {
+	w := uint64(1)
+	for w < uint64(width) {
+		w <<= 1
+	}
+	s := &sketch{width: w, samples: 10 * int(w)}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, w)
+	}
+	return s
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func (s *sketch) index(h uint64, i int) uint64 {
+	h1, h2 := h&0xffffffff, h>>32
+	return (h1 + uint64(i)*h2) & (s.width - 1)
+}
+
+func (s *sketch) increment(key string) {
+	h := hash(key)
+	for i := range s.counters {
+		if idx := s.index(h, i); s.counters[i][idx] < 15 {
+			s.counters[i][idx]++
+		}
+	}
+	s.added++
+	if s.added >= s.samples {
+		s.reset()
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	h := hash(key)
+	min := uint8(15)
+	for i := range s.counters {
+		if c := s.counters[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *sketch) reset() {
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] >>= 1
+		}
+	}
+	s.added /= 2
+}
@@ -0,0 +1,263 @@
+package tinylfu
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/qingants/pandora/minicache/lru"
+)
+
+// Value is use Len to count how many bytes it takes
+type Value = lru.Value
+
+const (
+	windowPercent    = 1  // share of maxBytes for the admission window
+	protectedPercent = 80 // share of the main space for protected entries
+	// sketchBytesPerKey is the expected entry size used to size the sketch
+	sketchBytesPerKey = 64
+	minSketchWidth    = 1 << 10
+	maxSketchWidth    = 1 << 20
+)
+
+// Cache is a W-TinyLFU cache: new entries land in a small LRU window and,
+// once pushed out of it, only enter the segmented LRU main space when the
+// frequency sketch says they are more popular than the entry they replace.
+type Cache struct {
+	maxBytes  int64
+	window    *segment
+	probation *segment
+	protected *segment
+	sketch    *sketch
+	cache     map[string]*list.Element
+	OnEvicted func(key string, value Value)
+}
+
+type segment struct {
+	ll       *list.List
+	nbytes   int64
+	maxBytes int64
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time // zero means never expire
+	seg    *segment
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+func New(maxBytes int64, onEvited func(string, Value)) *Cache {
+	windowBytes := maxBytes * windowPercent / 100
+	if maxBytes != 0 && windowBytes == 0 {
+		windowBytes = 1
+	}
+	mainBytes := maxBytes - windowBytes
+	protectedBytes := mainBytes * protectedPercent / 100
+
+	width := int(maxBytes / sketchBytesPerKey)
+	if width < minSketchWidth {
+		width = minSketchWidth
+	} else if width > maxSketchWidth {
+		width = maxSketchWidth
+	}
+	return &Cache{
+		maxBytes:  maxBytes,
+		window:    &segment{ll: list.New(), maxBytes: windowBytes},
+		probation: &segment{ll: list.New(), maxBytes: mainBytes - protectedBytes},
+		protected: &segment{ll: list.New(), maxBytes: protectedBytes},
+		sketch:    newSketch(width),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvited,
+	}
+}
+
+// Get looks up a key's value, an expired entry is removed and reported as a miss
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	c.sketch.increment(key)
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	if kv.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.hit(ele)
+	return kv.value, true
+}
+
+func (c *Cache) hit(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	switch kv.seg {
+	case c.window, c.protected:
+		kv.seg.ll.MoveToFront(ele)
+	case c.probation:
+		c.move(ele, c.protected)
+		for c.protected.nbytes > c.protected.maxBytes && c.protected.ll.Len() > 1 {
+			c.move(c.protected.ll.Back(), c.probation)
+		}
+	}
+}
+
+// Disuse evicts the next victim of the main space, or of the window once it is empty
+func (c *Cache) Disuse() {
+	if ele := c.victim(); ele != nil {
+		c.removeElement(ele)
+	} else if ele := c.window.ll.Back(); ele != nil {
+		c.removeElement(ele)
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+	}
+}
+
+// RemoveExpired drops every expired entry and returns how many were removed
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, seg := range []*segment{c.window, c.probation, c.protected} {
+		for ele := seg.ll.Back(); ele != nil; {
+			prev := ele.Prev()
+			if ele.Value.(*entry).expired(now) {
+				c.removeElement(ele)
+				n++
+			}
+			ele = prev
+		}
+	}
+	return n
+}
+
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire adds a value which is treated as missing once expire has passed,
+// a zero expire means the value never expires
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		kv.seg.nbytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expire = expire
+		c.hit(ele)
+	} else {
+		kv := &entry{key: key, value: value, expire: expire}
+		c.cache[key] = c.push(c.window, kv)
+	}
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.window.nbytes > c.window.maxBytes {
+		c.admit(c.window.ll.Back())
+	}
+	for c.probation.nbytes+c.protected.nbytes > c.probation.maxBytes+c.protected.maxBytes {
+		c.removeElement(c.victim())
+	}
+}
+
+// admit moves a candidate out of the window into probation if it is
+// more popular than the victims it would push out of the main space.
+// One larger than the window never had a chance to get popular in it
+// and is admitted without comparing frequencies
+func (c *Cache) admit(candidate *list.Element) {
+	kv := candidate.Value.(*entry)
+	mainBytes := c.probation.maxBytes + c.protected.maxBytes
+	if kv.size() > mainBytes {
+		c.removeElement(candidate)
+		return
+	}
+
+	bypass := kv.size() > c.window.maxBytes
+	freq := c.sketch.estimate(kv.key)
+	var victims []*list.Element
+	need := c.probation.nbytes + c.protected.nbytes + kv.size() - mainBytes
+	for ele := c.victim(); need > 0 && ele != nil; ele = c.nextVictim(ele) {
+		if !bypass && c.sketch.estimate(ele.Value.(*entry).key) >= freq {
+			c.removeElement(candidate)
+			return
+		}
+		victims = append(victims, ele)
+		need -= ele.Value.(*entry).size()
+	}
+	for _, ele := range victims {
+		c.removeElement(ele)
+	}
+	c.move(candidate, c.probation)
+}
+
+// victim returns the main space entry to evict first
+func (c *Cache) victim() *list.Element {
+	if ele := c.probation.ll.Back(); ele != nil {
+		return ele
+	}
+	return c.protected.ll.Back()
+}
+
+func (c *Cache) nextVictim(ele *list.Element) *list.Element {
+	if prev := ele.Prev(); prev != nil {
+		return prev
+	}
+	if ele.Value.(*entry).seg == c.probation {
+		return c.protected.ll.Back()
+	}
+	return nil
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	c.unlink(ele)
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+func (c *Cache) move(ele *list.Element, seg *segment) {
+	kv := ele.Value.(*entry)
+	c.unlink(ele)
+	c.cache[kv.key] = c.push(seg, kv)
+}
+
+func (c *Cache) push(seg *segment, kv *entry) *list.Element {
+	kv.seg = seg
+	seg.nbytes += kv.size()
+	return seg.ll.PushFront(kv)
+}
+
+func (c *Cache) unlink(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	kv.seg.ll.Remove(ele)
+	kv.seg.nbytes -= kv.size()
+}
+
+// Walk calls fn for every entry, probation, protected then the window
+// and least recently used first within each
+func (c *Cache) Walk(fn func(key string, value Value, expire time.Time)) {
+	for _, seg := range []*segment{c.probation, c.protected, c.window} {
+		for ele := seg.ll.Back(); ele != nil; ele = ele.Prev() {
+			kv := ele.Value.(*entry)
+			fn(kv.key, kv.value, kv.expire)
+		}
+	}
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes returns the bytes taken by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.window.nbytes + c.probation.nbytes + c.protected.nbytes
+}
@@ -0,0 +1,107 @@
+package tinylfu
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Str string
+
+func (d Str) Len() int {
+	return len(d)
+}
+
+func TestCache_Get(t *testing.T) {
+	c := New(0, nil)
+	c.Add("first", Str("abcd"))
+	if v, ok := c.Get("first"); !ok || string(v.(Str)) != "abcd" {
+		t.Fatalf("cache hit first=abcd failed")
+	}
+	if _, ok := c.Get("second"); ok {
+		t.Fatalf("cache miss second failed")
+	}
+}
+
+func TestCache_Admission(t *testing.T) {
+	evicted := 0
+	c := New(1000, func(key string, value Value) {
+		evicted++
+	})
+	// popular keys, read many times
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("hot%d", i)
+		for j := 0; j < 5; j++ {
+			if _, ok := c.Get(key); !ok {
+				c.Add(key, Str("0123456789012345"))
+			}
+		}
+	}
+	// a long scan of keys read once must not flush them
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("scan%d", i)
+		if _, ok := c.Get(key); !ok {
+			c.Add(key, Str("0123456789012345"))
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Get(fmt.Sprintf("hot%d", i)); !ok {
+			t.Fatalf("hot%d should survive the scan", i)
+		}
+	}
+	if c.Bytes() > 1000 || evicted == 0 {
+		t.Fatalf("bytes %d over budget, evicted %d", c.Bytes(), evicted)
+	}
+}
+
+func TestCache_LargerThanWindow(t *testing.T) {
+	c := New(1000, nil)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("hot%d", i)
+		for j := 0; j < 5; j++ {
+			if _, ok := c.Get(key); !ok {
+				c.Add(key, Str("0123456789012345"))
+			}
+		}
+	}
+	// a value set once is larger than the window, it must not be rejected
+	// for being less popular than the keys it pushes out
+	c.Add("large", Str(strings.Repeat("v", 100)))
+	if _, ok := c.Get("large"); !ok {
+		t.Fatalf("a value larger than the window should bypass admission")
+	}
+	if c.Bytes() > 1000 {
+		t.Fatalf("bytes %d over budget", c.Bytes())
+	}
+}
+
+func TestCache_Expire(t *testing.T) {
+	c := New(0, nil)
+	past := time.Now().Add(-time.Second)
+	c.AddWithExpire("k1", Str("v1"), past)
+	c.Add("k2", Str("v2"))
+	c.AddWithExpire("k3", Str("v3"), past)
+
+	if _, ok := c.Get("k1"); ok || c.Len() != 2 {
+		t.Fatalf("expired k1 should be a miss and removed")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.Len() != 1 || c.Bytes() != 4 {
+		t.Fatalf("RemoveExpired() = %d, len %d, bytes %d", n, c.Len(), c.Bytes())
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(16)
+	for i := 0; i < 5; i++ {
+		s.increment("a")
+	}
+	s.increment("b")
+	if s.estimate("a") < 5 || s.estimate("a") <= s.estimate("b") {
+		t.Fatalf("estimate a=%d b=%d", s.estimate("a"), s.estimate("b"))
+	}
+	s.reset()
+	if s.estimate("a") > 3 {
+		t.Fatalf("reset should halve counters, got %d", s.estimate("a"))
+	}
+}
@@ -0,0 +1,281 @@
+package minicache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/qingants/pandora/minicache/lru"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec turns the values of a TypedGroup into the bytes cached and
+// shared with peers, every node of a group must use the same one
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(b []byte) (T, error)
+}
+
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Unmarshal(b []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// ProtoCodec is the codec of generated message types, T is a pointer
+// such as *pb.Request
+type ProtoCodec[T proto.Message] struct{}
+
+func (ProtoCodec[T]) Marshal(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (ProtoCodec[T]) Unmarshal(b []byte) (T, error) {
+	var zero T
+	v := zero.ProtoReflect().New().Interface().(T)
+	err := proto.Unmarshal(b, v)
+	return v, err
+}
+
+// decodedCacheRatio is the share of cacheBytes, or of the Budget of a
+// group without it, given to decoded values counted by their encoded size.
+// defaultDecodedBytes is used for a group with neither
+const (
+	decodedCacheRatio   = 4
+	defaultDecodedBytes = 8 << 20
+)
+
+// TypedGroup is a Group of values of type T. Bytes are cached and sent
+// to peers as encoded by the codec, decoded values are kept for as long
+// as the group returns the same bytes. Callers share them and must not
+// modify the values they get
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+
+	decoded *decodedCache
+}
+
+// decodedCache holds the values decoded by a TypedGroup, they are charged
+// to the Budget of the group if it has one and evicted with its entries
+type decodedCache struct {
+	lock sync.Mutex
+	lru  *lru.Cache
+	mem  *memAccount
+}
+
+func (c *decodedCache) get(key string) (lru.Value, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Get(key)
+}
+
+func (c *decodedCache) add(key string, value lru.Value) {
+	c.track(func() { c.lru.Add(key, value) })
+	if c.mem != nil {
+		c.mem.budget.enforce()
+	}
+}
+
+func (c *decodedCache) remove(key string) {
+	c.track(func() { c.lru.Remove(key) })
+}
+
+// disuse evicts the least recently used value, it reports false if there was none
+func (c *decodedCache) disuse() bool {
+	if c.bytes() == 0 {
+		return false
+	}
+	c.track(c.lru.Disuse)
+	return true
+}
+
+// clear drops every value, the group is destroyed
+func (c *decodedCache) clear() {
+	c.track(func() {
+		for c.lru.Len() > 0 {
+			c.lru.Disuse()
+		}
+	})
+}
+
+func (c *decodedCache) bytes() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Bytes()
+}
+
+// track runs fn under the lock and charges the bytes it added or freed
+// to the budget of the group
+func (c *decodedCache) track(fn func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	before := c.lru.Bytes()
+	fn()
+	if c.mem != nil {
+		c.mem.charge(c.lru.Bytes() - before)
+	}
+}
+
+// decodedValue is a value decoded from the bytes starting at b
+type decodedValue[T any] struct {
+	b *byte
+	n int
+	v T
+}
+
+func (d *decodedValue[T]) Len() int {
+	return d.n
+}
+
+// NewTypedGroup creates a Group named name loading values of type T with loader
+func NewTypedGroup[T any](name string, cacheBytes int64, codec Codec[T],
+	loader func(ctx context.Context, key string) (T, error), opts ...*Option) *TypedGroup[T] {
+	if loader == nil {
+		panic("nil loader")
+	}
+	getter := GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := loader(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	})
+
+	opt := *parseOptions(opts...)
+	opt.decodedBytes = cacheBytes / decodedCacheRatio
+	if opt.decodedBytes <= 0 && opt.Budget != nil {
+		opt.decodedBytes = opt.Budget.Limit() / decodedCacheRatio
+	}
+	if opt.decodedBytes <= 0 {
+		opt.decodedBytes = defaultDecodedBytes
+	}
+	g := NewGroup(name, cacheBytes, getter, &opt)
+	return &TypedGroup[T]{
+		group:   g,
+		codec:   codec,
+		decoded: g.decoded,
+	}
+}
+
+// Group returns the group caching the encoded values
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+func (t *TypedGroup[T]) Get(key string) (T, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+func (t *TypedGroup[T]) GetContext(ctx context.Context, key string) (T, error) {
+	// compressed values stay so, decode keeps the value decoded from their bytes
+	view, err := t.group.get(ctx, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return t.decode(key, view)
+}
+
+// GetMany returns the values of keys, errors are as for Group.GetMany
+func (t *TypedGroup[T]) GetMany(keys []string) (map[string]T, error) {
+	return t.GetManyContext(context.Background(), keys)
+}
+
+func (t *TypedGroup[T]) GetManyContext(ctx context.Context, keys []string) (map[string]T, error) {
+	views, err := t.group.getMany(ctx, keys)
+	values := make(map[string]T, len(views))
+	for key, view := range views {
+		v, derr := t.decode(key, view)
+		if derr != nil {
+			errs, _ := err.(BatchError)
+			if errs == nil {
+				errs = BatchError{}
+			}
+			errs[key] = derr
+			err = errs
+			continue
+		}
+		values[key] = v
+	}
+	return values, err
+}
+
+func (t *TypedGroup[T]) Set(key string, value T, expire time.Time) error {
+	return t.SetContext(context.Background(), key, value, expire)
+}
+
+func (t *TypedGroup[T]) SetContext(ctx context.Context, key string, value T, expire time.Time) error {
+	b, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return t.group.SetContext(ctx, key, b, expire)
+}
+
+func (t *TypedGroup[T]) Remove(key string) error {
+	return t.RemoveContext(context.Background(), key)
+}
+
+func (t *TypedGroup[T]) RemoveContext(ctx context.Context, key string) error {
+	t.decoded.remove(key)
+	return t.group.RemoveContext(ctx, key)
+}
+
+// decode returns the value of view, reusing the one decoded last time
+// if the group still returns the same bytes for key
+func (t *TypedGroup[T]) decode(key string, view ByteView) (T, error) {
+	var first *byte
+	if len(view.b) > 0 {
+		first = &view.b[0]
+	}
+
+	if first != nil {
+		if d, ok := t.decoded.get(key); ok {
+			if d := d.(*decodedValue[T]); d.b == first && d.n == len(view.b) {
+				return d.v, nil
+			}
+		}
+	}
+
+	b, err := view.value()
+	if err != nil {
+		log.Printf("[MiniCache] Dropping corrupt value of %s %v", key, err)
+		t.group.removeCache(key)
+		var zero T
+		return zero, err
+	}
+	v, err := t.codec.Unmarshal(b)
+	if err != nil || first == nil {
+		return v, err
+	}
+
+	t.decoded.add(key, &decodedValue[T]{b: first, n: len(view.b), v: v})
+	return v, nil
+}
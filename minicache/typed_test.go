@@ -0,0 +1,105 @@
+package minicache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/pb"
+)
+
+type user struct {
+	Name  string
+	Score int
+}
+
+type countingCodec[T any] struct {
+	Codec[T]
+	decodes int
+}
+
+func (c *countingCodec[T]) Unmarshal(b []byte) (T, error) {
+	c.decodes++
+	return c.Codec.Unmarshal(b)
+}
+
+func TestTypedGroup(t *testing.T) {
+	loads := 0
+	codec := &countingCodec[user]{Codec: JSONCodec[user]{}}
+	g := NewTypedGroup[user]("typed", 2<<10, codec, func(ctx context.Context, key string) (user, error) {
+		loads++
+		return user{Name: key, Score: len(key)}, nil
+	})
+	defer DestroyGroup("typed")
+
+	for i := 0; i < 3; i++ {
+		if u, err := g.Get("rocky"); err != nil || u != (user{"rocky", 5}) {
+			t.Fatalf("unexpected value %+v %v", u, err)
+		}
+	}
+	if loads != 1 || codec.decodes != 1 {
+		t.Fatalf("expect 1 load and 1 decode, got %d loads %d decodes", loads, codec.decodes)
+	}
+
+	if err := g.Set("rocky", user{"rocky", 100}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := g.Get("rocky"); err != nil || u.Score != 100 {
+		t.Fatalf("expect the value set, got %+v %v", u, err)
+	}
+
+	values, err := g.GetMany([]string{"rocky", "amy"})
+	if err != nil || values["rocky"].Score != 100 || values["amy"] != (user{"amy", 3}) {
+		t.Fatalf("unexpected values %+v %v", values, err)
+	}
+}
+
+func TestTypedGroupBudget(t *testing.T) {
+	b := NewBudget(16 << 10)
+	g := NewTypedGroup[user]("typed-budget", 0, JSONCodec[user]{}, func(ctx context.Context, key string) (user, error) {
+		return user{Name: key, Score: len(key)}, nil
+	}, &Option{Budget: b})
+	for i := 0; i < 500; i++ {
+		if _, err := g.Get(fmt.Sprintf("key%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoded := g.decoded.bytes()
+	if decoded == 0 || decoded > b.Limit()/decodedCacheRatio {
+		t.Fatalf("expect decoded values kept within their share of the budget, got %d bytes", decoded)
+	}
+	if used := groupBytes(g.group) + decoded; used != b.Used() || used > b.Limit() {
+		t.Fatalf("group holds %d bytes with its decoded values, budget accounts for %d of %d", used, b.Used(), b.Limit())
+	}
+
+	DestroyGroup("typed-budget")
+	if b.Used() != 0 {
+		t.Fatalf("expect the budget released, %d bytes left", b.Used())
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	u := user{Name: "amy", Score: 3}
+	for _, codec := range []Codec[user]{JSONCodec[user]{}, GobCodec[user]{}} {
+		b, err := codec.Marshal(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := codec.Unmarshal(b); err != nil || v != u {
+			t.Fatalf("%T: expect %+v, got %+v %v", codec, u, v, err)
+		}
+	}
+
+	req := &pb.Request{Group: "scores", Key: "amy"}
+	codec := ProtoCodec[*pb.Request]{}
+	b, err := codec.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := codec.Unmarshal(b)
+	if err != nil || v.GetGroup() != "scores" || v.GetKey() != "amy" || reflect.ValueOf(v).Pointer() == reflect.ValueOf(req).Pointer() {
+		t.Fatalf("unexpected message %v %v", v, err)
+	}
+}
@@ -0,0 +1,178 @@
+package minicache
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingants/pandora/minirpc/xclient"
+)
+
+const defaultWatchInterval = 10 * time.Second
+
+// PeerWatcher reports the full peer list to update whenever it changes,
+// until stop is closed
+type PeerWatcher interface {
+	Watch(stop <-chan struct{}, update func(peers []string))
+}
+
+// watchPeers runs w in the background, feeding its lists to set
+func watchPeers(w PeerWatcher, set func(peers ...string)) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Watch(stop, func(peers []string) {
+			set(peers...)
+		})
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// FileWatcher re-reads a file listing one peer per line whenever its
+// modification time changes, blank lines and lines starting with # are skipped
+type FileWatcher struct {
+	Path     string
+	Interval time.Duration
+}
+
+func NewFileWatcher(path string, interval time.Duration) *FileWatcher {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &FileWatcher{Path: path, Interval: interval}
+}
+
+func (w *FileWatcher) Watch(stop <-chan struct{}, update func(peers []string)) {
+	var modTime time.Time
+	poll := func() {
+		info, err := os.Stat(w.Path)
+		if err != nil {
+			log.Printf("[MiniCache] Failed to stat peer file %v", err)
+			return
+		}
+		if info.ModTime().Equal(modTime) {
+			return
+		}
+		peers, err := readPeerFile(w.Path)
+		if err != nil {
+			log.Printf("[MiniCache] Failed to read peer file %v", err)
+			return
+		}
+		modTime = info.ModTime()
+		update(peers)
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for poll(); ; poll() {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func readPeerFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	return peers, scanner.Err()
+}
+
+// DiscoveryWatcher polls a minirpc discovery, eg an
+// xclient.MiniRegistryDiscovery, and reports its servers when they change
+type DiscoveryWatcher struct {
+	Discovery xclient.Discovery
+	Interval  time.Duration
+}
+
+func NewDiscoveryWatcher(d xclient.Discovery, interval time.Duration) *DiscoveryWatcher {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &DiscoveryWatcher{Discovery: d, Interval: interval}
+}
+
+func (w *DiscoveryWatcher) Watch(stop <-chan struct{}, update func(peers []string)) {
+	var last []string
+	poll := func() {
+		peers, err := w.Discovery.GetAll()
+		if err != nil {
+			log.Printf("[MiniCache] Failed to discover peers %v", err)
+			return
+		}
+		sort.Strings(peers)
+		if last != nil && equalPeers(last, peers) {
+			return
+		}
+		last = peers
+		update(peers)
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for poll(); ; poll() {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func equalPeers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffPeers returns the peers in next but not in prev and the other way round
+func diffPeers(prev, next []string) (added, removed []string) {
+	keep := make(map[string]bool, len(next))
+	for _, peer := range next {
+		keep[peer] = true
+	}
+	for _, peer := range prev {
+		if !keep[peer] {
+			removed = append(removed, peer)
+		}
+		delete(keep, peer)
+	}
+	for _, peer := range next {
+		if keep[peer] {
+			added = append(added, peer)
+			delete(keep, peer)
+		}
+	}
+	return
+}
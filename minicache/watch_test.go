@@ -0,0 +1,193 @@
+package minicache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/qingants/pandora/minicache/consistenthash"
+	"github.com/qingants/pandora/minicache/pb"
+	"github.com/qingants/pandora/minirpc/xclient"
+)
+
+func TestHTTPPoolAddRemovePeers(t *testing.T) {
+	p := NewHTTPPool("http://a")
+	if _, ok := p.PickPeer("k"); ok {
+		t.Fatalf("empty pool should not pick a peer")
+	}
+
+	p.AddPeers("http://a", "http://b", "http://c")
+	owners := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key := string(rune('a'+i%26)) + string(rune('0'+i/26))
+		owners[key] = p.picker.Get(key)
+	}
+
+	p.RemovePeers("http://c")
+	if !reflect.DeepEqual(p.Peers(), []string{"http://a", "http://b"}) || len(p.GetAll()) != 1 {
+		t.Fatalf("unexpected peers %v", p.Peers())
+	}
+	for key, owner := range owners {
+		if owner != "http://c" && p.picker.Get(key) != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, p.picker.Get(key))
+		}
+	}
+
+	p.Set("http://a", "http://d")
+	if !reflect.DeepEqual(p.Peers(), []string{"http://a", "http://d"}) {
+		t.Fatalf("unexpected peers %v", p.Peers())
+	}
+	if _, ok := p.peers["http://b"]; ok {
+		t.Fatalf("getter of removed peer is kept")
+	}
+}
+
+func waitPeers(t *testing.T, peers func() []string, want []string) {
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(peers(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("peers %v, want %v", peers(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFileWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	if err := os.WriteFile(path, []byte("# peers\nhttp://a\n\nhttp://b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewHTTPPool("http://a")
+	stop := p.Watch(NewFileWatcher(path, 10*time.Millisecond))
+	defer stop()
+	waitPeers(t, p.Peers, []string{"http://a", "http://b"})
+
+	if err := os.WriteFile(path, []byte("http://a\nhttp://c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the modification time may have a coarse resolution
+	later := time.Now().Add(time.Second)
+	_ = os.Chtimes(path, later, later)
+	waitPeers(t, p.Peers, []string{"http://a", "http://c"})
+}
+
+func TestDiscoveryWatcher(t *testing.T) {
+	d := xclient.NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"})
+	p := NewHTTPPool("tcp@a")
+	stop := p.Watch(NewDiscoveryWatcher(d, 10*time.Millisecond))
+	waitPeers(t, p.Peers, []string{"tcp@a", "tcp@b"})
+
+	_ = d.Update([]string{"tcp@b"})
+	waitPeers(t, p.Peers, []string{"tcp@b"})
+
+	stop()
+	_ = d.Update([]string{"tcp@c"})
+	time.Sleep(30 * time.Millisecond)
+	if !reflect.DeepEqual(p.Peers(), []string{"tcp@b"}) {
+		t.Fatalf("watcher kept running after stop")
+	}
+}
+
+func TestHTTPPoolPicker(t *testing.T) {
+	p := NewHTTPPool("http://a", &PoolOption{Picker: consistenthash.NewRendezvous(nil)})
+	p.Set("http://a", "http://b", "http://c")
+
+	picked := 0
+	for i := 0; i < 30; i++ {
+		if peer, ok := p.PickPeer(strconv.Itoa(i)); ok {
+			if peer == nil {
+				t.Fatalf("picked a nil peer")
+			}
+			picked++
+		}
+	}
+	if picked == 0 || picked == 30 {
+		t.Fatalf("rendezvous picked a peer for %d of 30 keys", picked)
+	}
+}
+
+func TestHTTPPoolUnknownNode(t *testing.T) {
+	ring := consistenthash.NewChecksumIEEE(defaultReplicas)
+	ring.AddWeighted("http://unknown", 3)
+	p := NewHTTPPool("http://a", &PoolOption{Picker: ring})
+	p.AddPeers("http://a", "http://b")
+
+	for i := 0; i < 30; i++ {
+		peers, _ := p.PickPeers(strconv.Itoa(i))
+		for _, peer := range peers {
+			if peerAddr(peer) == "http://unknown" {
+				t.Fatalf("picked a node the pool does not know")
+			}
+		}
+	}
+}
+
+func TestHTTPPoolWeightedPeers(t *testing.T) {
+	p := NewHTTPPool("http://a")
+	p.AddPeers("http://a")
+	p.AddWeightedPeer("http://b", 3)
+
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		if peer, ok := p.PickPeer(strconv.Itoa(i)); ok {
+			if peerAddr(peer) != "http://b" {
+				t.Fatalf("picked unexpected peer %s", peerAddr(peer))
+			}
+			picked++
+		}
+	}
+	if picked < 650 || picked > 850 {
+		t.Fatalf("expect about 3 in 4 keys on the heavy peer, got %d of 1000", picked)
+	}
+}
+
+func TestHTTPPoolBoundedLoads(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+	}))
+	defer srv.Close()
+
+	ring := consistenthash.NewChecksumIEEE(defaultReplicas)
+	ring.SetLoadFactor(1)
+	p := NewHTTPPool("http://a", &PoolOption{Picker: ring})
+	p.Set("http://a", "http://b", srv.URL)
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if ring.Get(strconv.Itoa(i)) == srv.URL {
+			key = strconv.Itoa(i)
+		}
+	}
+	peer, ok := p.PickPeer(key)
+	if !ok || peerAddr(peer) != srv.URL {
+		t.Fatalf("expect %s to own %s", srv.URL, key)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		peer.Get(context.Background(), &pb.Request{Group: "g", Key: key}, &pb.Response{})
+	}()
+	<-started
+	if ring.Load(srv.URL) != 1 {
+		t.Fatalf("expect the request in flight counted, got %d", ring.Load(srv.URL))
+	}
+	// the owner is full, the key goes to the next node on the ring
+	if owners := p.Owners(key); owners[0] == srv.URL {
+		t.Fatalf("expect a busy owner skipped, got %v", owners)
+	}
+	close(block)
+	<-done
+	if ring.Load(srv.URL) != 0 || p.Owners(key)[0] != srv.URL {
+		t.Fatalf("expect the load released and the key back on its owner")
+	}
+}
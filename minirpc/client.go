@@ -15,6 +15,14 @@ import (
 	"time"
 )
 
+// ServerError is an error returned by the remote service method,
+// as opposed to a failure reaching the server
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
 type Call struct {
 	Seq    uint64
 	Method string
@@ -93,7 +101,7 @@ func (c *Client) terminateCalls(err error) {
 
 	c.shutdown = true
 	for _, call := range c.pending {
-		call.Error = nil
+		call.Error = err
 		call.done()
 	}
 }
@@ -110,7 +118,7 @@ func (c *Client) receive() {
 		case call == nil:
 			err = c.codec.ReadBody(nil)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = ServerError(h.Error)
 			err = c.codec.ReadBody(nil)
 			call.done()
 		default:
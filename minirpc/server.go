@@ -1,6 +1,7 @@
 package minirpc
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -68,7 +69,8 @@ func (s *Server) ServerConn(conn io.ReadWriteCloser) {
 		}
 	}()
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
 		log.Printf("rpc server: options error %s", err.Error())
 		return
 	}
@@ -80,7 +82,22 @@ func (s *Server) ServerConn(conn io.ReadWriteCloser) {
 		log.Printf("rpc sever: invalid codec type %s", opt.CodecType)
 		return
 	}
-	s.serveCodec(f(conn), &opt)
+	// the json decoder may have read ahead into the first request,
+	// keep those bytes but drop the newline written by json.Encoder
+	r := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = r.Discard(1)
+	}
+	s.serveCodec(f(&bufferedConn{ReadWriteCloser: conn, r: r}), &opt)
+}
+
+type bufferedConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
 }
 
 var invalidRequest = struct{}{}